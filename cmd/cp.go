@@ -0,0 +1,328 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"bauklotze/pkg/api/backend"
+	"bauklotze/pkg/httpclient"
+	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/containers/podman/v5/pkg/errorhandling"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v3"
+)
+
+// guestPrefix marks the guest side of cp
+const guestPrefix = "vm:"
+
+var cpCmd = cli.Command{
+	Name:      "cp",
+	Usage:     "Copy files between the host and a running machine, prefix the guest path with \"vm:\"",
+	ArgsUsage: "SRC DST",
+	Action:    copyFiles,
+}
+
+var errCpArgs = errors.New("expect SRC and DST, exactly one of them prefixed with \"vm:\"")
+
+func copyFiles(ctx context.Context, cli *cli.Command) error {
+	if cli.Args().Len() != 2 { //nolint:mnd
+		return errCpArgs
+	}
+	src, dst := cli.Args().Get(0), cli.Args().Get(1)
+	srcGuest, dstGuest := strings.HasPrefix(src, guestPrefix), strings.HasPrefix(dst, guestPrefix)
+	if srcGuest == dstGuest {
+		return errCpArgs
+	}
+
+	opts := &vmconfig.VMOpts{
+		Workspace: cli.String("workspace"),
+		VMName:    cli.String("name"),
+	}
+	mc, err := vmconfig.LoadMachineFromPath(opts.GetVMConfigPath())
+	if err != nil {
+		return fmt.Errorf("load machine config file failed: %w", err)
+	}
+
+	c := &filesClient{
+		client: &http.Client{Transport: httpclient.CreateUnixTransport(mc.RestAPISocks)},
+	}
+	if dstGuest {
+		return c.upload(ctx, src, strings.TrimPrefix(dst, guestPrefix))
+	}
+	return c.download(ctx, strings.TrimPrefix(src, guestPrefix), dst)
+}
+
+// filesClient calls /files of the rest api of the start process
+type filesClient struct {
+	client *http.Client
+}
+
+func (c *filesClient) do(ctx context.Context, method string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     define.LocalHostURL,
+		Path:     "/files",
+		RawQuery: query.Encode(),
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to do request, is the machine running: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close() //nolint:errcheck
+		var em errorhandling.ErrorModel
+		if err := json.NewDecoder(resp.Body).Decode(&em); err != nil || em.Message == "" {
+			return nil, fmt.Errorf("%s %s: %s", method, u.Path, resp.Status)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, u.Path, em.Message)
+	}
+	return resp, nil
+}
+
+// upload copies a host file or directory to the guest, a guest path ending with / is the parent directory
+func (c *filesClient) upload(ctx context.Context, local, guest string) error {
+	if strings.HasSuffix(guest, "/") {
+		guest = path.Join(guest, filepath.Base(local))
+	}
+
+	info, err := os.Stat(local)
+	if err != nil {
+		return fmt.Errorf("stat %q failed: %w", local, err)
+	}
+
+	query := url.Values{"path": {guest}}
+	header := http.Header{}
+	var body io.Reader
+	if info.IsDir() {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeTar(pw, local))
+		}()
+		defer pr.Close() //nolint:errcheck
+		body = pr
+		header.Set("Content-Type", backend.ContentTypeTar)
+	} else {
+		f, err := os.Open(local)
+		if err != nil {
+			return fmt.Errorf("open %q failed: %w", local, err)
+		}
+		defer f.Close() //nolint:errcheck
+		body = f
+		query.Set("mode", strconv.FormatUint(uint64(info.Mode().Perm()), 8))
+		header.Set("Content-Type", "application/octet-stream")
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	logrus.Infof("Copied %q to guest %q", local, guest)
+	return nil
+}
+
+// download copies a guest file or directory to the host, an existing host directory receives the file
+func (c *filesClient) download(ctx context.Context, guest, local string) error {
+	resp, err := c.do(ctx, http.MethodGet, url.Values{"path": {guest}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == backend.ContentTypeTar {
+		if err := extractTar(resp.Body, local); err != nil {
+			return fmt.Errorf("extract %q into %q failed: %w", guest, local, err)
+		}
+		logrus.Infof("Copied guest %q to %q", guest, local)
+		return nil
+	}
+
+	if info, err := os.Stat(local); err == nil && info.IsDir() {
+		local = filepath.Join(local, path.Base(guest))
+	}
+	perm := fs.FileMode(define.DefaultFilePerm)
+	if mode, err := strconv.ParseUint(resp.Header.Get(backend.HeaderFileMode), 8, 32); err == nil {
+		perm = fs.FileMode(mode).Perm()
+	}
+
+	if err := writeFileAtomic(local, resp.Body, resp.ContentLength, perm); err != nil {
+		return fmt.Errorf("copy guest %q to %q failed: %w", guest, local, err)
+	}
+	logrus.Infof("Copied guest %q to %q", guest, local)
+	return nil
+}
+
+// writeFileAtomic writes r into file through a temporary file, so a broken transfer leaves no partial file
+func writeFileAtomic(file string, r io.Reader, size int64, perm fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".ovm-download-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file failed: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write %q failed: %w", tmp.Name(), err)
+	}
+	if size >= 0 && n != size {
+		return fmt.Errorf("short transfer, got %d of %d bytes", n, size)
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("chmod %q failed: %w", tmp.Name(), err)
+	}
+	return os.Rename(tmp.Name(), file) //nolint:wrapcheck
+}
+
+// writeTar writes the content of dir as a tar stream, the entries are relative to dir
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err //nolint:wrapcheck
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+		var link string
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(p); err != nil {
+				return err //nolint:wrapcheck
+			}
+		case !info.Mode().IsRegular() && !info.IsDir():
+			logrus.Warnf("Skip %q, only regular files, directories and symlinks are copied", p)
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		// the guest user owns the files
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err //nolint:wrapcheck
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+		defer f.Close() //nolint:errcheck
+		_, err = io.Copy(tw, f)
+		return err //nolint:wrapcheck
+	})
+	if err != nil {
+		return fmt.Errorf("archive %q failed: %w", dir, err)
+	}
+	return tw.Close() //nolint:wrapcheck
+}
+
+// extractTar extracts a tar stream into dir. The entries are created through os.Root, so they can not
+// escape dir by .. or symlinks, symlink entries are skipped.
+func extractTar(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil { //nolint:mnd
+		return fmt.Errorf("create %q failed: %w", dir, err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return fmt.Errorf("open %q failed: %w", dir, err)
+	}
+	defer root.Close() //nolint:errcheck
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar stream failed: %w", err)
+		}
+
+		name := path.Clean(hdr.Name)
+		if name == "." {
+			continue
+		}
+		perm := fs.FileMode(hdr.Mode).Perm() //nolint:gosec
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := mkdirAllInRoot(root, name, perm); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := mkdirAllInRoot(root, path.Dir(name), 0755); err != nil { //nolint:mnd
+				return err
+			}
+			f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+			if err != nil {
+				return fmt.Errorf("create %q failed: %w", name, err)
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("write %q failed: %w", name, err)
+			}
+		default:
+			logrus.Warnf("Skip %q, only regular files and directories are extracted", hdr.Name)
+		}
+	}
+}
+
+func mkdirAllInRoot(root *os.Root, name string, perm fs.FileMode) error {
+	if name == "." {
+		return nil
+	}
+	var cur string
+	for _, part := range strings.Split(name, "/") {
+		cur = path.Join(cur, part)
+		if err := root.Mkdir(cur, perm); err != nil && !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("create %q failed: %w", cur, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"bauklotze/pkg/httpclient"
+	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/diagnose"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v3"
+)
+
+var diagnoseCmd = cli.Command{
+	Name:   "diagnose",
+	Usage:  "Write a support bundle of the machine, with its config, logs, process and guest state",
+	Action: writeDiagnose,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "file of the bundle, default is ovm-diagnose-<name>-<time>.tar.gz in the current directory",
+		},
+	},
+}
+
+func writeDiagnose(ctx context.Context, cli *cli.Command) error {
+	opts := &vmconfig.VMOpts{
+		Workspace: cli.String("workspace"),
+		VMName:    cli.String("name"),
+	}
+	mc, err := vmconfig.LoadMachineFromPath(opts.GetVMConfigPath())
+	if err != nil {
+		return fmt.Errorf("load machine config file failed: %w", err)
+	}
+
+	output := cli.String("output")
+	if output == "" {
+		output = diagnose.FileName(mc)
+	}
+
+	// the start process has the console buffer and the event history, this process only has the files
+	err = downloadDiagnose(ctx, mc, output)
+	if err != nil {
+		logrus.Warnf("machine is not running or unreachable, collect the bundle locally: %v", err)
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(diagnose.Write(ctx, mc, pw))
+		}()
+		err = writeFileAtomic(output, pr, -1, define.DefaultFilePerm)
+		_ = pr.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("write support bundle failed: %w", err)
+	}
+
+	_, err = fmt.Fprintf(cli.Root().Writer, "%s\n", output)
+	return err //nolint:wrapcheck
+}
+
+// downloadDiagnose saves the bundle written by /diagnose of the start process
+func downloadDiagnose(ctx context.Context, mc *vmconfig.MachineConfig, output string) error {
+	client := &http.Client{Transport: httpclient.CreateUnixTransport(mc.RestAPISocks)}
+	u := url.URL{Scheme: "http", Host: define.LocalHostURL, Path: "/diagnose"}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// a missing start process fails at once, the socket is gone or refuses the connection
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to do request: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u.Path, resp.Status)
+	}
+
+	return writeFileAtomic(output, resp.Body, -1, define.DefaultFilePerm)
+}
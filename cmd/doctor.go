@@ -0,0 +1,61 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"bauklotze/pkg/machine/doctor"
+	"bauklotze/pkg/machine/events"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v3"
+)
+
+var doctorCmd = cli.Command{
+	Name:   "doctor",
+	Usage:  "Check the host for the problems which make init or start fail",
+	Action: runDoctor,
+	Before: func(ctx context.Context, command *cli.Command) (context.Context, error) {
+		events.CurrentStage = events.Doctor
+		return ctx, nil
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "vmm",
+			Usage: "vm provider checked if the machine is not initialized, support: krunkit, vfkit",
+			Value: vmconfig.GetVMM(),
+		},
+	},
+}
+
+func runDoctor(ctx context.Context, cli *cli.Command) error {
+	opts := &vmconfig.VMOpts{
+		Workspace: cli.String("workspace"),
+		VMName:    cli.String("name"),
+		VMM:       cli.String("vmm"),
+	}
+
+	mc, err := vmconfig.LoadMachineFromPath(opts.GetVMConfigPath())
+	if err != nil {
+		logrus.Infof("machine is not initialized, check the defaults of init: %v", err)
+		mc = vmconfig.NewMachineConfig(opts)
+	}
+
+	results := doctor.Run(ctx, mc)
+	for _, r := range results {
+		if _, err := fmt.Fprintf(cli.Root().Writer, "[%s] %s: %s\n", r.Status, r.Check, r.Message); err != nil {
+			return err //nolint:wrapcheck
+		}
+		if r.Remediation != "" {
+			if _, err := fmt.Fprintf(cli.Root().Writer, "\t%s\n", r.Remediation); err != nil {
+				return err //nolint:wrapcheck
+			}
+		}
+	}
+
+	return doctor.Failed(results)
+}
@@ -0,0 +1,121 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"bauklotze/pkg/machine/ignition"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+var ignitionCmd = cli.Command{
+	Name:   "ignition",
+	Usage:  "Print the ignition script the guest runs at boot, without starting the machine",
+	Action: renderIgnition,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "check",
+			Usage: "Check the syntax of the script with sh -n",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Output format, text or json",
+			Value: formatText,
+		},
+	},
+}
+
+var errIgnitionSyntax = errors.New("ignition script has syntax errors")
+
+func renderIgnition(ctx context.Context, cli *cli.Command) error {
+	format := cli.String("format")
+	if format != formatText && format != formatJSON {
+		return fmt.Errorf("unsupported format %q", format)
+	}
+
+	opts := &vmconfig.VMOpts{
+		Workspace: cli.String("workspace"),
+		VMName:    cli.String("name"),
+	}
+
+	mc, err := vmconfig.LoadMachineFromPath(opts.GetVMConfigPath())
+	if err != nil {
+		return fmt.Errorf("load machine config file failed: %w", err)
+	}
+
+	ign, err := ignition.Preview(mc)
+	if err != nil {
+		return fmt.Errorf("render ignition script failed: %w", err)
+	}
+
+	rendered := ign.Rendered()
+	if cli.Bool("check") {
+		if rendered.Check, err = ignition.CheckSyntax(ctx, rendered.Script); err != nil {
+			return fmt.Errorf("check ignition script failed: %w", err)
+		}
+	}
+
+	// stdout is redirected into the log file, the original one is kept by the root command
+	out := cli.Root().Writer
+	if format == formatJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rendered)
+	} else {
+		err = printIgnition(out, rendered)
+	}
+	if err != nil {
+		return fmt.Errorf("print ignition script failed: %w", err)
+	}
+
+	if rendered.Check != nil && !rendered.Check.OK {
+		return errIgnitionSyntax
+	}
+	return nil
+}
+
+// printIgnition prints the script with a comment before each section, so the output is still a valid script
+func printIgnition(w io.Writer, rendered *ignition.Rendered) error {
+	for _, s := range rendered.Sections {
+		inputs, err := json.Marshal(s.Inputs)
+		if err != nil {
+			return fmt.Errorf("failed to encode inputs of section %q: %w", s.Name, err)
+		}
+		if _, err := fmt.Fprintf(w, "# ---- section: %s\n# inputs: %s\n%s\n", s.Name, inputs, s.Script); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	if rendered.Check == nil {
+		return nil
+	}
+
+	status := "ok"
+	if !rendered.Check.OK {
+		status = "failed"
+	}
+	_, err := fmt.Fprintf(w, "# ---- sh -n: %s\n", status)
+	if err == nil && rendered.Check.Output != "" {
+		_, err = fmt.Fprintf(w, "%s", commentLines(rendered.Check.Output))
+	}
+	return err //nolint:wrapcheck
+}
+
+func commentLines(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	return "# " + strings.Join(lines, "\n# ") + "\n"
+}
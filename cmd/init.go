@@ -12,6 +12,7 @@ import (
 	"runtime"
 
 	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/doctor"
 	"bauklotze/pkg/machine/events"
 	"bauklotze/pkg/machine/fs"
 	"bauklotze/pkg/machine/shim"
@@ -65,6 +66,74 @@ var initCmd = cli.Command{
 			Value:    "v1.0",
 			Required: true,
 		},
+		&cli.StringSliceFlag{
+			Name:  "provision-script",
+			Usage: "Script appended to the ignition script in the given order, prefix with \"once:\" to run it only until it succeeded once",
+		},
+		&cli.StringSliceFlag{
+			Name:  "ca-cert",
+			Usage: "PEM bundle on the host installed into the guest trust store",
+		},
+		&cli.BoolFlag{
+			Name:  "proxy-from-env",
+			Usage: "Propagate HTTP_PROXY, HTTPS_PROXY and NO_PROXY of the start process into the VM",
+		},
+		&cli.StringFlag{
+			Name:  "http-proxy",
+			Usage: "HTTP proxy used in the VM, localhost is replaced by the host address",
+		},
+		&cli.StringFlag{
+			Name:  "https-proxy",
+			Usage: "HTTPS proxy used in the VM, localhost is replaced by the host address",
+		},
+		&cli.StringFlag{
+			Name:  "no-proxy",
+			Usage: "Comma separated hosts which bypass the proxy in the VM",
+		},
+		&cli.StringSliceFlag{
+			Name:  "registry-mirror",
+			Usage: "Mirrors of a registry used in the VM, in format of registry=mirror1,mirror2",
+		},
+		&cli.StringSliceFlag{
+			Name:  "insecure-registry",
+			Usage: "Registry accessed without TLS verification in the VM",
+		},
+		&cli.StringSliceFlag{
+			Name:  "search-registry",
+			Usage: "Registry used to resolve unqualified image names in the VM",
+		},
+		&cli.StringFlag{
+			Name:  "registry-auth-file",
+			Usage: "auth.json on the host copied into the VM",
+		},
+		&cli.BoolFlag{
+			Name:  "docker-socket",
+			Usage: "Serve a docker compatible api socket docker.sock in the socks dir, e.g. for DOCKER_HOST",
+		},
+		&cli.BoolFlag{
+			Name:  "auto-publish-ports",
+			Usage: "Expose the published ports of the containers in the VM on the host's 127.0.0.1",
+		},
+		&cli.IntFlag{
+			Name:  "exec-max-sessions",
+			Usage: "Number of commands the rest api runs in the VM at the same time, 0 means the default",
+		},
+		&cli.IntFlag{
+			Name:  "exec-queue-length",
+			Usage: "Number of exec requests waiting for a free session, 0 means the default",
+		},
+		&cli.IntFlag{
+			Name:  "exec-queue-timeout",
+			Usage: "Seconds an exec request waits for a free session, 0 means the default",
+		},
+		&cli.IntFlag{
+			Name:  "exec-output-buffer",
+			Usage: "Bytes of command output buffered for a slow exec client before the command is paused, 0 means the default",
+		},
+		&cli.IntFlag{
+			Name:  "exec-max-jobs",
+			Usage: "Number of background jobs running in the VM at the same time, 0 means the default",
+		},
 		&cli.StringFlag{
 			Name:  "vmm",
 			Usage: "vm provider, support: krunkit, vfkit",
@@ -74,6 +143,11 @@ var initCmd = cli.Command{
 }
 
 func initMachine(ctx context.Context, cli *cli.Command) error {
+	mirrors, err := vmconfig.RegistryMirrorsFromCmdLine(cli.StringSlice("registry-mirror"))
+	if err != nil {
+		return fmt.Errorf("parse registry mirrors failed: %w", err)
+	}
+
 	opts := &vmconfig.VMOpts{
 		VMName:      cli.String("name"),
 		Workspace:   cli.String("workspace"),
@@ -85,12 +159,38 @@ func initMachine(ctx context.Context, cli *cli.Command) error {
 		BootVersion: cli.String("boot-version"),
 		DataVersion: cli.String("data-version"),
 		VMM:         cli.String("vmm"),
+
+		ProvisionScripts: cli.StringSlice("provision-script"),
+		CACerts:          cli.StringSlice("ca-cert"),
+		Proxy: vmconfig.ProxyConfig{
+			FromEnv:    cli.Bool("proxy-from-env"),
+			HTTPProxy:  cli.String("http-proxy"),
+			HTTPSProxy: cli.String("https-proxy"),
+			NoProxy:    cli.String("no-proxy"),
+		},
+		Registries: vmconfig.RegistriesConfig{
+			Mirrors:  mirrors,
+			Insecure: cli.StringSlice("insecure-registry"),
+			Search:   cli.StringSlice("search-registry"),
+			AuthFile: cli.String("registry-auth-file"),
+		},
+		AutoPublishPorts: cli.Bool("auto-publish-ports"),
+		DockerSocket:     cli.Bool("docker-socket"),
+		Exec: vmconfig.ExecLimits{
+			MaxSessions:  int(cli.Int("exec-max-sessions")),
+			QueueLength:  int(cli.Int("exec-queue-length")),
+			QueueTimeout: int(cli.Int("exec-queue-timeout")),
+			OutputBuffer: int(cli.Int("exec-output-buffer")),
+			MaxJobs:      int(cli.Int("exec-max-jobs")),
+		},
 	}
 
-	migrateData(opts)
+	events.NotifyInit(events.InitPreflight)
+	if err := doctor.Failed(doctor.Run(ctx, vmconfig.NewMachineConfig(opts))); err != nil {
+		return err //nolint:wrapcheck
+	}
 
-	// add a default mount point that store generated ignition scripts
-	opts.Volumes = append(opts.Volumes, define.IgnMnt)
+	migrateData(opts)
 
 	vmcFile := opts.GetVMConfigPath()
 
@@ -5,13 +5,14 @@ package main
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
+	"bauklotze/pkg/logrotate"
 	"bauklotze/pkg/machine/define"
 	"bauklotze/pkg/machine/events"
-	"bauklotze/pkg/machine/fs"
 	"bauklotze/pkg/machine/vmconfig"
 
 	"github.com/sirupsen/logrus"
@@ -36,6 +37,16 @@ func main() {
 				Usage: "where to write the log, support file, stdout, default is file",
 				Value: define.LogOutFile,
 			},
+			&cli.StringFlag{
+				Name:  "log-format",
+				Usage: "log format, support text, json",
+				Value: define.LogFormatText,
+			},
+			&cli.StringFlag{
+				Name:  "log-level",
+				Usage: "log level, support trace, debug, info, warn, error",
+				Value: logrus.InfoLevel.String(),
+			},
 			&cli.StringFlag{
 				Name:  "report-url",
 				Usage: "URL to send report events to",
@@ -49,13 +60,21 @@ func main() {
 		Commands: []*cli.Command{
 			&initCmd,
 			&startCmd,
+			&ignitionCmd,
+			&cpCmd,
+			&diagnoseCmd,
+			&doctorCmd,
 		},
 		Before: func(ctx context.Context, command *cli.Command) (context.Context, error) {
 			events.SetReportURL(command.String("report-url"))
 			// a GLOB var that stores the workspace value, this var only need be initialized once
 			vmconfig.Workspace = command.String("workspace")
 			logFile := filepath.Join(vmconfig.Workspace, command.String("name"), define.LogPrefixDir, define.LogFileName)
-			loggerSetup(command.String("log-out"), logFile)
+			// only the long-lived start process rotates the log files, the other commands append to them
+			rotate := command.Args().First() == startCmd.Name
+			if err := loggerSetup(command.String("log-out"), logFile, command.String("log-format"), command.String("log-level"), rotate); err != nil {
+				return ctx, err
+			}
 			return ctx, nil
 		},
 	}
@@ -74,45 +93,68 @@ func notifyAndExit(err error) {
 	logrus.Exit(exitCode)
 }
 
-const MaxSizeInMB = 5
-
 // loggerSetup outType: file, stdout
 // if outType is file, workspace is required
 // if outType is terminal, workspace is not required, all output will be sent to Terminal's stdout/stderr
-func loggerSetup(outType string, f string) {
-	logrus.SetLevel(logrus.InfoLevel)
-	logrus.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp:   true,
-		ForceColors:     true,
-		DisableColors:   false,
-		TimestampFormat: "2006-01-02 15:04:05.000",
-	})
+// the text format is colored only if the output is a terminal
+// rotate must only be set in one process of the machine, see openLogFile
+func loggerSetup(outType, f, format, level string, rotate bool) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+	logrus.SetLevel(lvl)
+
+	switch format {
+	case define.LogFormatText:
+		logrus.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:04:05.000",
+		})
+	case define.LogFormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
+		})
+	default:
+		return fmt.Errorf("invalid log format %q, support %s, %s", format, define.LogFormatText, define.LogFormatJSON)
+	}
 
 	logrus.SetOutput(os.Stderr)
 
 	if outType == define.LogOutFile {
-		logFile := fs.NewFile(f)
-		if logFile.IsExist() {
-			logrus.Infof("Log file %q already exists, discarding the first 5 Mib", logFile.GetPath())
-			if err := logFile.DiscardBytesAtBegin(MaxSizeInMB); err != nil {
-				logrus.Warnf("failed to discard log file: %q", err)
-			}
+		logFile, err := openLogFile(f, rotate)
+		if err != nil {
+			logrus.Warnf("failed to open log file: %v", err)
+			return nil
 		}
+		logrus.Infof("Save log to %q", f)
+		logrus.SetOutput(logFile)
 
-		logrus.Infof("Save log to %q", logFile.GetPath())
-		if err := logFile.MakeBaseDir(); err != nil {
-			logrus.Warnf("failed to create log directory: %q", err)
-			return
+		// os.Stdout and os.Stderr must be files, their output reaches the log file through a pipe
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			logrus.Warnf("failed to redirect stdout and stderr into log file: %v", err)
+			return nil
 		}
+		go func() {
+			_, _ = io.Copy(logFile, pr)
+		}()
+		os.Stdout = pw
+		os.Stderr = pw
+	}
+	return nil
+}
 
-		fd, err := os.OpenFile(logFile.GetPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666) //nolint:mnd
-		if err != nil && !errors.Is(err, os.ErrNotExist) {
-			logrus.Warnf("failed to open log file: %q", err)
-			return
-		}
+// openLogFile opens f for appending. If rotate is set, f is rotated by size and age while running, see
+// logrotate.DefaultOptions. pkg/logrotate only coordinates the renames within one process, so a short-lived
+// command, e.g. cp while the machine is running, must not rotate the file the start process is writing.
+func openLogFile(f string, rotate bool) (io.Writer, error) {
+	if rotate {
+		return logrotate.New(f, logrotate.DefaultOptions()) //nolint:wrapcheck
+	}
 
-		os.Stdout = fd
-		os.Stderr = fd
-		logrus.SetOutput(fd)
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil { //nolint:mnd
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
 	}
+	return os.OpenFile(f, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644) //nolint:mnd,wrapcheck
 }
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"bauklotze/pkg/api/server"
+	"bauklotze/pkg/machine/doctor"
 	"bauklotze/pkg/machine/events"
 	"bauklotze/pkg/machine/krunkit"
 	"bauklotze/pkg/machine/shim"
@@ -57,6 +58,11 @@ func start(parentCtx context.Context, cli *cli.Command) error {
 		return fmt.Errorf("load machine config file failed: %w", err)
 	}
 
+	events.NotifyRun(events.Preflight)
+	if err := doctor.Failed(doctor.Run(parentCtx, mc)); err != nil {
+		return err //nolint:wrapcheck
+	}
+
 	g, ctx := errgroup.WithContext(parentCtx)
 
 	// WatchPPID
@@ -5,7 +5,6 @@ go 1.24.0
 toolchain go1.24.1
 
 require (
-	github.com/Code-Hex/go-infinity-channel v1.0.0
 	github.com/DataDog/zstd v1.5.6
 	github.com/containers/common v0.61.0
 	github.com/containers/gvisor-tap-vsock v0.8.5
@@ -15,9 +14,9 @@ require (
 	github.com/creack/pty v1.1.24
 	github.com/go-playground/validator/v10 v10.25.0
 	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
 	github.com/json-iterator/go v1.1.12
 	github.com/oomol-lab/ovm-ssh-agent/v3 v3.0.0
-	github.com/oomol-lab/ssh-forward v0.0.0-20250507105559-91763745d836
 	github.com/prashantgupta24/mac-sleep-notifier v1.0.1
 	github.com/shirou/gopsutil/v4 v4.25.3
 	github.com/sirupsen/logrus v1.9.3
@@ -0,0 +1,289 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/ssh/service"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Every websocket message of /exec/attach is a binary message, the first byte is the channel
+// and the rest is the payload
+const (
+	// attachStdin carries the input of the process, an empty payload closes the stdin
+	attachStdin byte = iota
+	attachStdout
+	attachStderr
+	// attachStatus is the last message sent by the server, the payload is an attachStatusMsg
+	attachStatus
+	// attachResize is sent by the client when the terminal size changes, the payload is an attachResizeMsg
+	attachResize
+)
+
+const (
+	defaultTerm     = "xterm-256color"
+	defaultCols     = 80
+	defaultRows     = 24
+	attachWriteWait = 10 * time.Second
+)
+
+type attachResizeMsg struct {
+	Cols int `json:"cols"`
+	Rows int `json:"rows"`
+}
+
+type attachStatusMsg struct {
+	ExitCode int    `json:"exitCode"`
+	Signal   string `json:"signal,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+var attachUpgrader = websocket.Upgrader{
+	// the api is served on a unix socket owned by the user, there is no browser origin to check
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// attachConn serializes the writes to the websocket
+type attachConn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *attachConn) send(channel byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_ = c.ws.SetWriteDeadline(time.Now().Add(attachWriteWait))
+	return c.ws.WriteMessage(websocket.BinaryMessage, append([]byte{channel}, payload...)) //nolint:wrapcheck
+}
+
+func (c *attachConn) close(code int, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_ = c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(attachWriteWait))
+	_ = c.ws.Close()
+}
+
+// channelWriter sends everything written to it as messages of a channel
+type channelWriter struct {
+	conn    *attachConn
+	channel byte
+}
+
+func (w *channelWriter) Write(p []byte) (int, error) {
+	if err := w.conn.send(w.channel, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type attachOpts struct {
+	command string
+	tty     bool
+	term    string
+	cols    int
+	rows    int
+}
+
+func parseAttachOpts(r *http.Request) (*attachOpts, error) {
+	q := r.URL.Query()
+	opts := &attachOpts{
+		command: q.Get("command"),
+		tty:     true,
+		term:    q.Get("term"),
+		cols:    defaultCols,
+		rows:    defaultRows,
+	}
+	if opts.term == "" {
+		opts.term = defaultTerm
+	}
+
+	if v := q.Get("tty"); v != "" {
+		tty, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tty %q: %w", v, err)
+		}
+		opts.tty = tty
+	}
+
+	for name, dst := range map[string]*int{"cols": &opts.cols, "rows": &opts.rows} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", name, v)
+		}
+		*dst = n
+	}
+
+	return opts, nil
+}
+
+// AttachExec runs a command in the guest and attaches its stdio to a websocket, the login shell is
+// started if no command is given. Query: command, tty (default true), term, cols, rows.
+// The attached command holds an exec session until it exits.
+func AttachExec(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request /exec/attach")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	opts, err := parseAttachOpts(r)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	release, ok := acquireExec(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	client, err := service.GuestClient(mc)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the session is created before the upgrade, so a broken ssh connection is still reported by a status code
+	session, err := client.NewSession(r.Context())
+	if err != nil {
+		utils.Error(w, http.StatusBadGateway, fmt.Errorf("create session error: %w", err))
+		return
+	}
+	defer session.Close() //nolint:errcheck
+
+	ws, err := attachUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has replied the error
+		logrus.Warnf("upgrade to websocket failed: %v", err)
+		return
+	}
+	conn := &attachConn{ws: ws}
+
+	status := runAttached(conn, session, opts)
+	logrus.Infof("attached command %q finished: %+v", opts.command, status)
+
+	b, _ := json.Marshal(status)
+	if err := conn.send(attachStatus, b); err != nil {
+		logrus.Warnf("send exit status failed: %v", err)
+	}
+	conn.close(websocket.CloseNormalClosure, "")
+}
+
+func runAttached(conn *attachConn, session *ssh.Session, opts *attachOpts) *attachStatusMsg {
+	fail := func(err error) *attachStatusMsg {
+		return &attachStatusMsg{ExitCode: -1, Error: err.Error()}
+	}
+
+	if opts.tty {
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400, //nolint:mnd
+			ssh.TTY_OP_OSPEED: 14400, //nolint:mnd
+		}
+		if err := session.RequestPty(opts.term, opts.rows, opts.cols, modes); err != nil {
+			return fail(fmt.Errorf("request pty failed: %w", err))
+		}
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fail(fmt.Errorf("failed to get session.StdinPipe(): %w", err))
+	}
+	session.Stdout = &channelWriter{conn: conn, channel: attachStdout}
+	session.Stderr = &channelWriter{conn: conn, channel: attachStderr}
+
+	if opts.command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(opts.command)
+	}
+	if err != nil {
+		return fail(fmt.Errorf("failed to start command: %w", err))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go readAttachInput(ctx, conn, session, stdin)
+
+	err = session.Wait()
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return &attachStatusMsg{}
+	case errors.As(err, &exitErr):
+		if exitErr.Signal() != "" {
+			return &attachStatusMsg{ExitCode: -1, Signal: exitErr.Signal()}
+		}
+		return &attachStatusMsg{ExitCode: exitErr.ExitStatus()}
+	default:
+		return fail(err)
+	}
+}
+
+// readAttachInput dispatches the client messages until the websocket is closed, the process is
+// hung up if the client goes away first
+func readAttachInput(ctx context.Context, conn *attachConn, session *ssh.Session, stdin io.WriteCloser) {
+	for {
+		_, msg, err := conn.ws.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil {
+				logrus.Warnf("attach client disconnected: %v", err)
+				_ = session.Signal(ssh.SIGHUP)
+				_ = session.Close()
+			}
+			return
+		}
+		if len(msg) == 0 {
+			continue
+		}
+
+		switch channel, payload := msg[0], msg[1:]; channel {
+		case attachStdin:
+			if len(payload) == 0 {
+				_ = stdin.Close()
+				continue
+			}
+			if _, err := stdin.Write(payload); err != nil {
+				logrus.Warnf("write stdin failed: %v", err)
+			}
+		case attachResize:
+			var size attachResizeMsg
+			if err := json.Unmarshal(payload, &size); err != nil || size.Cols <= 0 || size.Rows <= 0 {
+				logrus.Warnf("invalid resize message %q", payload)
+				continue
+			}
+			if err := session.WindowChange(size.Rows, size.Cols); err != nil {
+				logrus.Warnf("window change failed: %v", err)
+			}
+		default:
+			logrus.Warnf("unknown attach channel %d", channel)
+		}
+	}
+}
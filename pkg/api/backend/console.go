@@ -0,0 +1,83 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/console"
+
+	"github.com/sirupsen/logrus"
+)
+
+// eventConsole carries the guest console output sent by /console?follow=1
+const eventConsole = "console"
+
+// stripCR removes the \r of the \r\n sent by the pty, a \r would end a line in SSE
+func stripCR(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte("\r"), nil)
+}
+
+// GetConsole replies the kept tail of the guest console. With ?follow=1 the tail and the new output
+// are streamed as console events, and dropped carries the bytes missed by a slow client.
+func GetConsole(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request /console")
+
+	follow := false
+	if v := r.URL.Query().Get("follow"); v != "" {
+		var err error
+		if follow, err = strconv.ParseBool(v); err != nil {
+			utils.Error(w, http.StatusBadRequest, fmt.Errorf("invalid follow %q: %w", v, err))
+			return
+		}
+	}
+
+	if !follow {
+		content, _ := console.Output.Snapshot()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(content)
+		return
+	}
+
+	if _, ok := w.(http.Flusher); !ok {
+		utils.Error(w, http.StatusInternalServerError, ErrStreamNotSupport)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	// start from the kept tail, the output before it is not reported as dropped
+	tail, offset := console.Output.Snapshot()
+	offset -= int64(len(tail))
+	for {
+		content, next, dropped, changed := console.Output.Since(offset)
+		offset = next
+		if dropped > 0 {
+			writeSSE(w, sseEvent{name: eventDropped, data: strconv.FormatInt(dropped, 10)})
+		}
+		if len(content) > 0 {
+			writeSSE(w, sseEvent{name: eventConsole, data: string(stripCR(content))})
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			logrus.Infof("Console client disconnected")
+			return
+		case <-time.After(3 * time.Second): //nolint:mnd
+			_, _ = fmt.Fprintf(w, ": ping\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}
+}
@@ -0,0 +1,36 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"fmt"
+	"net/http"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/diagnose"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Diagnose replies the support bundle of the machine as a gzipped tarball
+func Diagnose(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request /diagnose")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", diagnose.FileName(mc)))
+	w.WriteHeader(http.StatusOK)
+
+	// the status is sent, a failure can only be logged, the client sees a broken gzip stream
+	if err := diagnose.Write(r.Context(), mc, w); err != nil {
+		logrus.Warnf("write support bundle failed: %v", err)
+	}
+}
@@ -6,7 +6,17 @@ package backend
 import "errors"
 
 var (
-	ErrMachineConfigNull = errors.New("machineConfig is null")
-	ErrStreamNotSupport  = errors.New("stream not support")
-	ErrStopVMFailed      = errors.New("stop vm failed")
+	ErrMachineConfigNull   = errors.New("machineConfig is null")
+	ErrStreamNotSupport    = errors.New("stream not support")
+	ErrStopVMFailed        = errors.New("stop vm failed")
+	ErrApplyRegistries     = errors.New("apply registries config failed")
+	ErrJobManagerNull      = errors.New("job manager is null")
+	ErrJobNotFound         = errors.New("job not found")
+	ErrJobsSaturated       = errors.New("too many running jobs")
+	ErrExecLimiterNull     = errors.New("exec limiter is null")
+	ErrInvalidGuestPath    = errors.New("path must be an absolute guest path")
+	ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+	ErrPortConflict        = errors.New("port conflict")
+	ErrPortNotFound        = errors.New("port forward not found")
+	ErrLogNotFound         = errors.New("log not found")
 )
@@ -0,0 +1,99 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"errors"
+	"sync"
+)
+
+var errQueueAborted = errors.New("event queue aborted")
+
+// eventQueue passes the events of a command to the sse client. It holds at most limit bytes of data,
+// the writer blocks when it is full, so a slow client pauses the command instead of growing the memory.
+type eventQueue struct {
+	mu      sync.Mutex
+	events  []sseEvent
+	size    int
+	limit   int
+	closed  bool
+	aborted bool
+	// changed is closed and replaced on every change
+	changed chan struct{}
+}
+
+func newEventQueue(limit int) *eventQueue {
+	return &eventQueue{
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+}
+
+// notify wakes up the reader and the writers, q.mu must be held
+func (q *eventQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// put blocks until there is room for e, an event is always accepted by an empty queue.
+// errQueueAborted is returned once the reader is gone.
+func (q *eventQueue) put(e sseEvent) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for !q.aborted && len(q.events) > 0 && q.size+len(e.data) > q.limit {
+		changed := q.changed
+		q.mu.Unlock()
+		<-changed
+		q.mu.Lock()
+	}
+	if q.aborted {
+		return errQueueAborted
+	}
+
+	q.events = append(q.events, e)
+	q.size += len(e.data)
+	q.notify()
+	return nil
+}
+
+// take returns all the queued events, changed is closed on the next put, closed is set if no more put comes
+func (q *eventQueue) take() (events []sseEvent, changed <-chan struct{}, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	events, q.events, q.size = q.events, nil, 0
+	if len(events) > 0 {
+		q.notify()
+	}
+	return events, q.changed, q.closed && len(events) == 0
+}
+
+// close is called by the writer after the last put
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notify()
+}
+
+// abort is called by the reader when it stops reading, the blocked writers return errQueueAborted
+func (q *eventQueue) abort() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.aborted = true
+	q.notify()
+}
+
+type queueWriter struct {
+	q    *eventQueue
+	name string
+}
+
+func (w *queueWriter) Write(p []byte) (int, error) {
+	if err := w.q.put(sseEvent{name: w.name, data: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -4,82 +4,121 @@
 package backend
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"strings"
-	"sync"
 	"time"
 
 	"bauklotze/pkg/api/types"
 	"bauklotze/pkg/api/utils"
-	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/ssh/service"
 	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/ssh"
 
-	"github.com/Code-Hex/go-infinity-channel"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/ssh"
 )
 
-func exec(ctx context.Context, mc *vmconfig.MachineConfig, command string, outCh *infinity.Channel[string], errCh chan string) error {
-	key, err := os.ReadFile(mc.SSH.PrivateKeyPath)
-	if err != nil {
-		return fmt.Errorf("failed to read private key: %w", err)
+var errExecBody = errors.New("exactly one of command and args must be given")
+
+// SSE events sent by /exec
+const (
+	eventStdout = "stdout"
+	eventStderr = "stderr"
+	// eventExit carries an execExit, it is sent once the command finished
+	eventExit = "exit"
+	// eventError is sent if the command can not run, no exit event follows
+	eventError = "error"
+	eventDone  = "done"
+	// eventDropped carries the number of output bytes dropped by the output limit
+	eventDropped = "dropped"
+)
+
+type execBody struct {
+	// Command is run by the remote shell
+	Command string `json:"command"`
+	// Args is the argv of the command, it is not interpreted by any shell
+	Args []string          `json:"args"`
+	Env  map[string]string `json:"env"`
+	Cwd  string            `json:"cwd"`
+	// Stdin is base64 encoded
+	Stdin string `json:"stdin"`
+	// Timeout in seconds, the command is killed after it. Zero means no timeout
+	Timeout int `json:"timeout"`
+}
+
+type execExit struct {
+	ExitCode   int    `json:"exitCode"`
+	Signal     string `json:"signal,omitempty"`
+	TimedOut   bool   `json:"timedOut"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+func (b *execBody) newCmd(ctx context.Context, mc *vmconfig.MachineConfig) (*ssh.Cmd, error) {
+	if (b.Command == "") == (len(b.Args) == 0) {
+		return nil, errExecBody
 	}
-	signer, err := ssh.ParsePrivateKey(key)
-	if err != nil {
-		return fmt.Errorf("failed to parse private key: %w", err)
+	if b.Timeout < 0 {
+		return nil, fmt.Errorf("invalid timeout %d", b.Timeout)
 	}
 
-	connCfg := &ssh.ClientConfig{
-		User:            mc.SSH.RemoteUsername,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-	}
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", define.LocalHostURL, mc.SSH.Port), connCfg)
+	stdin, err := base64.StdEncoding.DecodeString(b.Stdin)
 	if err != nil {
-		errCh <- fmt.Sprintf("dial ssh error %s", err)
-		return fmt.Errorf("dial ssh error %w", err)
+		return nil, fmt.Errorf("invalid stdin: %w", err)
 	}
-	defer conn.Close() //nolint:errcheck
 
-	session, err := conn.NewSession()
+	myCmd, err := service.NewCmd(ctx, mc, "", nil)
 	if err != nil {
-		errCh <- fmt.Sprintf("create session error %s", err)
-		return fmt.Errorf("create session error %w", err)
+		return nil, err //nolint:wrapcheck
 	}
-	defer session.Close() //nolint:errcheck
-
-	context.AfterFunc(ctx, func() {
-		_ = session.Signal(ssh.SIGKILL)
-		_ = conn.Close()
-	})
-
-	w := ch2Writer(outCh)
-	session.Stdout = w
-	stderr := recordWriter(w)
-	session.Stderr = stderr
-
-	logrus.Infof("starting exec command: '%s'", command)
-	if err := session.Run(command); err != nil {
-		newErr := fmt.Errorf("%s\n%s", stderr.LastRecord(), err) //nolint:errorlint
-		errCh <- newErr.Error()
-		return fmt.Errorf("run command error: %w", newErr)
+	if b.Command != "" {
+		myCmd.SetShell(ctx, b.Command)
+	} else {
+		myCmd.SetArgs(ctx, b.Args...)
 	}
-	logrus.Infof("exec command finished")
+	myCmd.SetEnv(b.Env)
+	myCmd.SetDir(b.Cwd)
+	myCmd.SetStdin(bytes.NewReader(stdin))
+	myCmd.SetTimeout(time.Duration(b.Timeout) * time.Second)
 
-	return nil
+	return myCmd, nil
 }
 
-type execBody struct {
-	Command string `json:"command"`
+func exec(myCmd *ssh.Cmd, events *eventQueue) {
+	myCmd.SetOutput(&queueWriter{q: events, name: eventStdout}, &queueWriter{q: events, name: eventStderr})
+
+	logrus.Infof("starting exec command: %s", myCmd.String())
+	result, err := myCmd.Run()
+	if err != nil && (result == nil || !errors.Is(err, ssh.ErrTimeout)) {
+		logrus.Warnf("exec command error: %v", err)
+		_ = events.put(sseEvent{name: eventError, data: err.Error()})
+		return
+	}
+
+	exit := execExit{
+		ExitCode:   result.ExitCode,
+		Signal:     result.Signal,
+		TimedOut:   errors.Is(err, ssh.ErrTimeout),
+		DurationMs: result.Duration.Milliseconds(),
+	}
+	logrus.Infof("exec command finished: %+v", exit)
+
+	b, _ := json.Marshal(exit)
+	_ = events.put(sseEvent{name: eventExit, data: string(b)})
 }
 
+// DoExec runs a command in the guest and streams its output as server-sent events:
+// stdout and stderr carry the output, exit carries the exit code, and done ends the stream.
+// 429 is replied if no session is free within the queue timeout.
 func DoExec(w http.ResponseWriter, r *http.Request) {
 	logrus.Infof("Request /exec")
 
@@ -95,107 +134,69 @@ func DoExec(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	// when sse client closes the connection, the r.Context() get cancel immediately
+	myCmd, err := body.newCmd(r.Context(), mc)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
 
 	if _, ok := w.(http.Flusher); !ok {
 		utils.Error(w, http.StatusInternalServerError, ErrStreamNotSupport)
 		return
 	}
 
-	outCh := infinity.NewChannel[string]()
-	errCh := make(chan string, 1)
-	doneCh := make(chan struct{}, 1)
-
-	go func() {
-		// when sse client closes the connection, the r.Context() get cancel immediately
-		if err := exec(r.Context(), mc, body.Command, outCh, errCh); err != nil {
-			logrus.Warn(err.Error())
-		}
+	release, ok := acquireExec(w, r)
+	if !ok {
+		return
+	}
 
-		doneCh <- struct{}{}
-		outCh.Close()
-		close(errCh)
-	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
 
-	defer func() {
-		select {
-		case <-r.Context().Done():
-		default:
-			_, _ = fmt.Fprintf(w, "event: done\n")
-			_, _ = fmt.Fprintf(w, "data: done\n\n") // end of date
-			w.(http.Flusher).Flush()
-		}
+	events := newEventQueue(execLimiter(r).limits.OutputBuffer)
+	// the command is paused by a full queue, unblock it when the client goes away
+	defer events.abort()
+	go func() {
+		defer release()
+		exec(myCmd, events)
+		events.close()
 	}()
 
 	for {
-		select {
-		case <-doneCh:
+		batch, changed, closed := events.take()
+		for _, e := range batch {
+			writeSSE(w, e)
+		}
+		if closed {
 			logrus.Infof("Command execution finished")
+			writeSSE(w, sseEvent{name: eventDone, data: "done"})
 			return
-		case err, ok := <-errCh:
-			if !ok {
-				continue
-			}
-			logrus.Warnf("Command execution error: %s", err)
-			_, _ = fmt.Fprintf(w, "event: error\n")
-			_, _ = fmt.Fprintf(w, "data: %s\n\n", encodeSSE(err))
-			w.(http.Flusher).Flush()
-			continue
-		case out, ok := <-outCh.Out():
-			if !ok {
-				continue
-			}
-			_, _ = fmt.Fprintf(w, "event: out\n")
-			_, _ = fmt.Fprintf(w, "data: %s\n\n", encodeSSE(out))
-			w.(http.Flusher).Flush()
+		}
+		if len(batch) > 0 {
 			continue
+		}
+
+		select {
+		case <-changed:
 		case <-r.Context().Done():
 			logrus.Warnf("Client disconnected")
 			return
 		case <-time.After(3 * time.Second): //nolint:mnd
 			_, _ = fmt.Fprintf(w, ": ping\n\n")
 			w.(http.Flusher).Flush()
-			continue
 		}
 	}
 }
 
-type chWriter struct {
-	ch *infinity.Channel[string]
-	mu sync.Mutex
-}
-
-func (w *chWriter) Write(p []byte) (n int, err error) {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	w.ch.In() <- string(p)
-	return len(p), nil
-}
-
-func ch2Writer(ch *infinity.Channel[string]) io.Writer {
-	return &chWriter{ch: ch}
-}
-
-type writer struct {
-	w    io.Writer
-	last []byte
-}
-
-func (w *writer) Write(p []byte) (n int, err error) {
-	w.last = p
-	return w.w.Write(p) //nolint:wrapcheck
-}
-
-func (w *writer) LastRecord() string {
-	return string(w.last)
-}
-
-func recordWriter(w io.Writer) *writer {
-	return &writer{w: w}
+func writeSSE(w http.ResponseWriter, e sseEvent) {
+	_, _ = fmt.Fprintf(w, "event: %s\n", e.name)
+	_, _ = fmt.Fprintf(w, "data: %s\n\n", encodeSSE(e.data))
+	w.(http.Flusher).Flush()
 }
 
+// encodeSSE splits data into data lines, the client joins them with \n so the output is kept as is
 func encodeSSE(str string) string {
-	return strings.ReplaceAll(strings.TrimSpace(str), "\n", "\ndata: ")
+	return strings.ReplaceAll(str, "\n", "\ndata: ")
 }
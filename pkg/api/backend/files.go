@@ -0,0 +1,418 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/ssh/service"
+	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/ssh"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The file transfers are streamed through the stdio of guest commands on the shared ssh connection, not SFTP.
+//
+// SFTP needs the sftp subsystem configured in the guest sshd and github.com/pkg/sftp in the host. The boot
+// image does not promise the former and the module does not depend on the latter, while the scripts below
+// only need a POSIX shell, tar and coreutils, which the ignition script relies on already. They cover what
+// the endpoints need of SFTP:
+//   - a file is written into a temporary file and renamed, with the requested mode
+//   - a directory is a tar stream, packed and unpacked by the guest tar in one pass
+//   - a download opens the file once as fd 3 and stats the opened file, so the reported size and the
+//     streamed range refer to the same file, see downloadScript
+//   - the body of a file which shrinks while it is read is cut short, the response is then aborted
+//     with http.ErrAbortHandler so the client does not take it for a complete file
+
+const (
+	// ContentTypeTar marks a directory transfer, the body is a tar stream of the directory content
+	ContentTypeTar = "application/x-tar"
+	// HeaderFileMode is the permission bits of a downloaded file, in octal
+	HeaderFileMode = "X-File-Mode"
+
+	defaultUploadMode = 0644
+
+	// exit codes of the guest scripts, they are mapped to status codes
+	exitNotFound    = 44
+	exitIsDirectory = 45
+	exitNotDir      = 46
+)
+
+// uploadFileScript writes stdin into $1 atomically with the mode $2
+const uploadFileScript = `set -e
+[ -d "$1" ] && { echo "$1 is a directory" >&2; exit 45; }
+mkdir -p -- "$(dirname -- "$1")"
+tmp="$1.ovm-upload.$$"
+trap 'rm -f -- "$tmp"' EXIT
+cat > "$tmp"
+chmod "$2" "$tmp"
+mv -f -- "$tmp" "$1"
+trap - EXIT
+`
+
+// uploadDirScript extracts the tar stream of stdin into the directory $1
+const uploadDirScript = `set -e
+[ -e "$1" ] && [ ! -d "$1" ] && { echo "$1 is not a directory" >&2; exit 46; }
+mkdir -p -- "$1"
+tar -xpf - -C "$1"
+`
+
+// downloadScript prints the type|size|mode|mtime line of $1, then its content: a tar stream of a directory,
+// or the range "offset length" of a file read from stdin. The file is opened once and stat'ed by its fd,
+// so the size sent as Content-Length is the size of the bytes that follow, even if the path is replaced.
+const downloadScript = `[ -e "$1" ] || { echo "$1 does not exist" >&2; exit 44; }
+if [ -d "$1" ]; then
+  cd -- "$1" || exit
+  stat -L -c '%F|%s|%a|%Y' . || exit
+  exec tar -cf - .
+fi
+exec 3< "$1" || exit
+stat -L -c '%F|%s|%a|%Y' /dev/fd/3 || exit
+read -r offset length || exit
+tail -c +"$((offset + 1))" <&3 | head -c "$length"
+`
+
+type fileStat struct {
+	isDir   bool
+	size    int64
+	mode    string
+	modTime time.Time
+}
+
+type uploadResp struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// guestPath returns the absolute guest path of the ?path= query
+func guestPath(r *http.Request) (string, error) {
+	p := r.URL.Query().Get("path")
+	if p == "" || !path.IsAbs(p) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidGuestPath, p)
+	}
+	return path.Clean(p), nil
+}
+
+// runScript runs a script in guest with the positional parameters args
+func runScript(ctx context.Context, mc *vmconfig.MachineConfig, script string, stdin io.Reader, stdout io.Writer, args ...string) (*ssh.Result, error) {
+	myCmd, err := service.NewCmd(ctx, mc, "", nil)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	myCmd.SetArgs(ctx, append([]string{"sh", "-c", script, "sh"}, args...)...)
+	if stdin != nil {
+		myCmd.SetStdin(stdin)
+	}
+	if stdout != nil {
+		myCmd.SetOutput(stdout, nil)
+	}
+
+	result, err := myCmd.Run()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	return result, nil
+}
+
+// scriptError replies the failure of a guest script
+func scriptError(w http.ResponseWriter, result *ssh.Result, err error) {
+	if err != nil {
+		utils.Error(w, http.StatusBadGateway, err)
+		return
+	}
+
+	code := http.StatusInternalServerError
+	switch result.ExitCode {
+	case exitNotFound:
+		code = http.StatusNotFound
+	case exitIsDirectory, exitNotDir:
+		code = http.StatusConflict
+	}
+	utils.Error(w, code, result.Err())
+}
+
+// parseStat parses the type|size|mode|mtime line printed by downloadScript
+func parseStat(line string) (*fileStat, error) {
+	fields := strings.Split(strings.TrimSpace(line), "|")
+	if len(fields) != 4 { //nolint:mnd
+		return nil, fmt.Errorf("unexpected stat output %q", line)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected stat size %q: %w", fields[1], err)
+	}
+	mtime, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected stat mtime %q: %w", fields[3], err)
+	}
+
+	return &fileStat{
+		isDir:   fields[0] == "directory",
+		size:    size,
+		mode:    fields[2],
+		modTime: time.Unix(mtime, 0),
+	}, nil
+}
+
+var errDownloadReplied = errors.New("download replied before the content")
+
+// downloadWriter receives the stdout of downloadScript. The stat line is passed to onStat, which replies
+// the headers and returns the writer of the content, or an error if the content is not wanted.
+type downloadWriter struct {
+	line   []byte
+	onStat func(stat *fileStat) (io.Writer, error)
+	out    io.Writer
+	err    error
+}
+
+func (d *downloadWriter) Write(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.out != nil {
+		return d.out.Write(p) //nolint:wrapcheck
+	}
+
+	i := bytes.IndexByte(p, '\n')
+	if i < 0 {
+		d.line = append(d.line, p...)
+		return len(p), nil
+	}
+	d.line = append(d.line, p[:i]...)
+
+	stat, err := parseStat(string(d.line))
+	if err == nil {
+		d.out, err = d.onStat(stat)
+	}
+	if err != nil {
+		d.err = err
+		return 0, err
+	}
+
+	if _, err := d.out.Write(p[i+1:]); err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+	return len(p), nil
+}
+
+// countingWriter counts the bytes written into w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err //nolint:wrapcheck
+}
+
+// parseRange parses a single byte range of the Range header. ok is false if the header is absent or
+// not supported, then the whole file is sent.
+func parseRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	end := size - 1
+	switch {
+	case first == "":
+		// the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, ErrRangeNotSatisfiable
+		}
+		start = max(size-n, 0)
+	default:
+		start, err = strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return 0, 0, false, ErrRangeNotSatisfiable
+		}
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return 0, 0, false, ErrRangeNotSatisfiable
+			}
+			end = min(end, size-1)
+		}
+	}
+
+	if start >= size {
+		return 0, 0, false, ErrRangeNotSatisfiable
+	}
+	return start, end - start + 1, true, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err //nolint:wrapcheck
+}
+
+// UploadFile writes the body into the guest file of ?path=, with the permission of ?mode= (default 0644).
+// A body of Content-Type application/x-tar is extracted into the guest directory of ?path= instead.
+func UploadFile(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request PUT /files")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	file, err := guestPath(r)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	mode := strconv.FormatInt(defaultUploadMode, 8)
+	if v := r.URL.Query().Get("mode"); v != "" {
+		if _, err := strconv.ParseUint(v, 8, 12); err != nil { //nolint:mnd
+			utils.Error(w, http.StatusBadRequest, fmt.Errorf("invalid mode %q, expect octal permission bits", v))
+			return
+		}
+		mode = v
+	}
+
+	script, args := uploadFileScript, []string{file, mode}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == ContentTypeTar {
+		script, args = uploadDirScript, []string{file}
+	}
+
+	release, ok := acquireExec(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	body := &countingReader{r: r.Body}
+	result, err := runScript(r.Context(), mc, script, body, nil, args...)
+	if err != nil || result.Err() != nil {
+		scriptError(w, result, err)
+		return
+	}
+
+	logrus.Infof("uploaded %d bytes to %q", body.n, file)
+	utils.WriteJSON(w, http.StatusCreated, &uploadResp{Path: file, Bytes: body.n})
+}
+
+// DownloadFile sends the guest file of ?path=, a single byte range is supported.
+// A directory is sent as a tar stream of its content.
+func DownloadFile(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request GET /files")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	file, err := guestPath(r)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	release, ok := acquireExec(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// the range is sent to the guest once the size of the opened file is known
+	rangeReader, rangeWriter := io.Pipe()
+	defer rangeWriter.Close() //nolint:errcheck
+
+	body := &countingWriter{w: w}
+	want := int64(-1)
+	onStat := func(stat *fileStat) (io.Writer, error) {
+		defer rangeWriter.Close() //nolint:errcheck
+
+		w.Header().Set(HeaderFileMode, stat.mode)
+		w.Header().Set("Last-Modified", stat.modTime.UTC().Format(http.TimeFormat))
+		if stat.isDir {
+			w.Header().Set("Content-Type", ContentTypeTar)
+			w.WriteHeader(http.StatusOK)
+			return body, nil
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Accept-Ranges", "bytes")
+
+		status := http.StatusOK
+		start, length, partial, err := parseRange(r.Header.Get("Range"), stat.size)
+		switch {
+		case err != nil:
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", stat.size))
+			utils.Error(w, http.StatusRequestedRangeNotSatisfiable, err)
+			return nil, errDownloadReplied
+		case partial:
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, stat.size))
+			status = http.StatusPartialContent
+		default:
+			start, length = 0, stat.size
+		}
+
+		if _, err := fmt.Fprintf(rangeWriter, "%d %d\n", start, length); err != nil {
+			return nil, fmt.Errorf("failed to send range: %w", err)
+		}
+		want = length
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(status)
+		return body, nil
+	}
+
+	stdout := &downloadWriter{onStat: onStat}
+	result, err := runScript(r.Context(), mc, downloadScript, rangeReader, stdout, file)
+	if stdout.out == nil {
+		// nothing is replied if the script failed before the stat line
+		if errors.Is(stdout.err, errDownloadReplied) {
+			return
+		}
+		if err == nil {
+			err = stdout.err
+		}
+		if err == nil && result.Err() == nil {
+			err = fmt.Errorf("download %q: %w", file, io.ErrUnexpectedEOF)
+		}
+		scriptError(w, result, err)
+		return
+	}
+
+	// the status is sent, a failure can only be logged
+	if err == nil {
+		err = result.Err()
+	}
+	if err != nil && !errors.Is(r.Context().Err(), context.Canceled) {
+		logrus.Warnf("download %q failed: %v", file, err)
+	}
+	// the file shrank while it was read, a short body must not look complete to the client
+	if want >= 0 && body.n != want {
+		logrus.Warnf("download %q sent %d bytes, expect %d, abort the response", file, body.n, want)
+		panic(http.ErrAbortHandler)
+	}
+}
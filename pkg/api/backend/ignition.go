@@ -0,0 +1,46 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"fmt"
+	"net/http"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/ignition"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RenderIgnition returns the ignition script generated from the current machine config,
+// with ?check=1 the script is also checked by sh -n
+func RenderIgnition(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request /ignition")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	mc.Lock()
+	ign, err := ignition.Render(mc)
+	mc.Unlock()
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, fmt.Errorf("render ignition script failed: %w", err))
+		return
+	}
+
+	rendered := ign.Rendered()
+	if check := r.URL.Query().Get("check"); check == "1" || check == "true" {
+		if rendered.Check, err = ignition.CheckSyntax(r.Context(), rendered.Script); err != nil {
+			utils.Error(w, http.StatusInternalServerError, fmt.Errorf("check ignition script failed: %w", err))
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, rendered)
+}
@@ -16,6 +16,8 @@ import (
 
 type Resp struct {
 	PodmanSocketPath string `json:"podmanSocketPath"`
+	// DockerSocketPath is the docker compatible socket, for DOCKER_HOST=unix://<path>
+	DockerSocketPath string `json:"dockerSocketPath,omitempty"`
 	SSHPort          int    `json:"sshPort"`
 	SSHUser          string `json:"sshUser"`
 	HostEndpoint     string `json:"hostEndpoint"`
@@ -35,6 +37,7 @@ func GetInfos(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteJSON(w, http.StatusOK, &Resp{
 		PodmanSocketPath: mc.PodmanSocks.InHost,
+		DockerSocketPath: mc.DockerSocks,
 		SSHPort:          mc.SSH.Port,
 		SSHUser:          mc.SSH.RemoteUsername,
 		HostEndpoint:     hostEndPoint,
@@ -0,0 +1,313 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/ssh"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxRetainedJobs is the number of finished jobs kept for GET /jobs, the oldest ones are removed first
+	maxRetainedJobs = 100
+	// jobOutputLimit is the output kept for each job, the head is dropped first
+	jobOutputLimit = 1 << 20
+)
+
+var (
+	errJobCanceled  = errors.New("job canceled")
+	errJobsShutdown = errors.New("api server is shutting down")
+)
+
+type jobState string
+
+const (
+	jobRunning jobState = "running"
+	// jobExited means the command exited, see its exit code
+	jobExited jobState = "exited"
+	// jobFailed means the command could not run to the end, e.g. the ssh connection is lost
+	jobFailed jobState = "failed"
+	// jobCancelled means the job was killed by DELETE /jobs/{id} or by the shutdown of the api server
+	jobCancelled jobState = "cancelled"
+)
+
+type job struct {
+	id        string
+	command   string
+	args      []string
+	cmd       *ssh.Cmd
+	cancel    context.CancelCauseFunc
+	output    *jobOutput
+	startedAt time.Time
+
+	mu         sync.Mutex
+	state      jobState
+	exit       *execExit
+	err        string
+	finishedAt time.Time
+}
+
+type jobInfo struct {
+	ID         string     `json:"id"`
+	Command    string     `json:"command,omitempty"`
+	Args       []string   `json:"args,omitempty"`
+	State      jobState   `json:"state"`
+	Exit       *execExit  `json:"exit,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Stdout and Stderr are only returned for a single job
+	Stdout *string `json:"stdout,omitempty"`
+	Stderr *string `json:"stderr,omitempty"`
+	// DroppedBytes is the output dropped by the output limit
+	DroppedBytes int64 `json:"droppedBytes"`
+}
+
+func (j *job) info(withOutput bool) *jobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	info := &jobInfo{
+		ID:        j.id,
+		Command:   j.command,
+		Args:      j.args,
+		State:     j.state,
+		Exit:      j.exit,
+		Error:     j.err,
+		StartedAt: j.startedAt,
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		info.FinishedAt = &finishedAt
+	}
+
+	stdout, stderr, dropped := j.output.streams()
+	info.DroppedBytes = dropped
+	if withOutput {
+		info.Stdout, info.Stderr = &stdout, &stderr
+	}
+	return info
+}
+
+func (j *job) running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state == jobRunning
+}
+
+func (j *job) run() {
+	j.cmd.SetOutput(&streamWriter{output: j.output, stream: eventStdout}, &streamWriter{output: j.output, stream: eventStderr})
+
+	logrus.Infof("job %s starting: %s", j.id, j.cmd.String())
+	result, err := j.cmd.Run()
+
+	j.mu.Lock()
+	j.finishedAt = time.Now()
+	switch {
+	// the command reports the cause of the context, so a kill is not taken for a failure of the command
+	case errors.Is(err, errJobCanceled) || errors.Is(err, errJobsShutdown):
+		j.state = jobCancelled
+		j.err = err.Error()
+	case err != nil && (result == nil || !errors.Is(err, ssh.ErrTimeout)):
+		j.state = jobFailed
+		j.err = err.Error()
+	default:
+		j.state = jobExited
+		j.exit = &execExit{
+			ExitCode:   result.ExitCode,
+			Signal:     result.Signal,
+			TimedOut:   errors.Is(err, ssh.ErrTimeout),
+			DurationMs: result.Duration.Milliseconds(),
+		}
+	}
+	logrus.Infof("job %s finished: state %s, exit %+v, error %q", j.id, j.state, j.exit, j.err)
+	j.mu.Unlock()
+
+	j.output.close()
+}
+
+// JobManager keeps the background exec jobs of the start process. The jobs are limited on their own,
+// they do not take the exec sessions of the interactive requests.
+type JobManager struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	maxJobs int
+	// ctx is the parent of all the jobs, it is canceled by Shutdown
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+}
+
+type JobManagerStats struct {
+	Running int `json:"running"`
+	MaxJobs int `json:"maxJobs"`
+}
+
+func NewJobManager(limits vmconfig.ExecLimits) *JobManager {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return &JobManager{
+		jobs:    make(map[string]*job),
+		maxJobs: limits.WithDefaults().MaxJobs,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 6) //nolint:mnd
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// start runs the command of body in background, it is not bound to the request but to the manager.
+// ErrJobsSaturated is returned if MaxJobs jobs are running.
+func (m *JobManager) start(mc *vmconfig.MachineConfig, body *execBody) (*job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := context.Cause(m.ctx); err != nil {
+		return nil, err
+	}
+	if running := m.runningLocked(); running >= m.maxJobs {
+		return nil, fmt.Errorf("%w: %d of %d", ErrJobsSaturated, running, m.maxJobs)
+	}
+
+	ctx, cancel := context.WithCancelCause(m.ctx)
+	myCmd, err := body.newCmd(ctx, mc)
+	if err != nil {
+		cancel(err)
+		return nil, err
+	}
+
+	j := &job{
+		id:        id,
+		command:   body.Command,
+		args:      body.Args,
+		cmd:       myCmd,
+		cancel:    cancel,
+		output:    newJobOutput(jobOutputLimit),
+		startedAt: time.Now(),
+		state:     jobRunning,
+	}
+
+	m.jobs[id] = j
+	m.pruneLocked()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel(nil)
+		j.run()
+	}()
+
+	return j, nil
+}
+
+// runningLocked counts the running jobs, m.mu must be held
+func (m *JobManager) runningLocked() int {
+	running := 0
+	for _, j := range m.jobs {
+		if j.running() {
+			running++
+		}
+	}
+	return running
+}
+
+func (m *JobManager) Stats() JobManagerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return JobManagerStats{
+		Running: m.runningLocked(),
+		MaxJobs: m.maxJobs,
+	}
+}
+
+// Shutdown cancels all the running jobs and waits for them, no job can be started afterwards
+func (m *JobManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.cancel(errJobsShutdown)
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for jobs to stop: %w", context.Cause(ctx))
+	}
+}
+
+func (m *JobManager) get(id string) (*job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	return j, ok
+}
+
+// list returns the jobs sorted by start time
+func (m *JobManager) list() []*job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]*job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].startedAt.Before(jobs[b].startedAt)
+	})
+	return jobs
+}
+
+func (m *JobManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+}
+
+// pruneLocked removes the oldest finished jobs above maxRetainedJobs, m.mu must be held
+func (m *JobManager) pruneLocked() {
+	var finished []*job
+	for _, j := range m.jobs {
+		if !j.running() {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= maxRetainedJobs {
+		return
+	}
+
+	sort.Slice(finished, func(a, b int) bool {
+		return finished[a].startedAt.Before(finished[b].startedAt)
+	})
+	for _, j := range finished[:len(finished)-maxRetainedJobs] {
+		delete(m.jobs, j.id)
+	}
+}
@@ -0,0 +1,131 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"sync"
+)
+
+type outputChunk struct {
+	stream string
+	data   []byte
+	// offset is the position of the chunk in the whole output
+	offset int64
+}
+
+// jobOutput keeps the last limit bytes of the output of a job, the followers are woken up on every change
+type jobOutput struct {
+	mu      sync.Mutex
+	chunks  []outputChunk
+	size    int
+	total   int64
+	limit   int
+	closed  bool
+	changed chan struct{}
+}
+
+func newJobOutput(limit int) *jobOutput {
+	return &jobOutput{
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+}
+
+func (o *jobOutput) write(stream string, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.chunks = append(o.chunks, outputChunk{
+		stream: stream,
+		data:   append([]byte(nil), p...),
+		offset: o.total,
+	})
+	o.total += int64(len(p))
+	o.size += len(p)
+
+	for o.size > o.limit && len(o.chunks) > 1 {
+		o.size -= len(o.chunks[0].data)
+		o.chunks = o.chunks[1:]
+	}
+	if o.size > o.limit {
+		// a single chunk larger than the limit, keep its tail
+		cut := o.size - o.limit
+		o.chunks[0].data = o.chunks[0].data[cut:]
+		o.chunks[0].offset += int64(cut)
+		o.size = o.limit
+	}
+
+	o.notify()
+}
+
+// close marks the end of the output
+func (o *jobOutput) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.closed = true
+	o.notify()
+}
+
+// notify wakes up the followers, o.mu must be held
+func (o *jobOutput) notify() {
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+// since returns the chunks after offset and the number of bytes dropped between offset and them.
+// next is the offset to continue with, changed is closed on the next write, closed is set if no more write comes.
+func (o *jobOutput) since(offset int64) (chunks []outputChunk, dropped, next int64, changed <-chan struct{}, closed bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, c := range o.chunks {
+		end := c.offset + int64(len(c.data))
+		if end <= offset {
+			continue
+		}
+		if c.offset < offset {
+			c.data = c.data[offset-c.offset:]
+			c.offset = offset
+		}
+		if len(chunks) == 0 && c.offset > offset {
+			dropped = c.offset - offset
+		}
+		chunks = append(chunks, c)
+	}
+
+	if len(chunks) == 0 && o.total > offset {
+		dropped = o.total - offset
+	}
+
+	return chunks, dropped, o.total, o.changed, o.closed
+}
+
+// streams returns the kept output of each stream, and the number of dropped bytes
+func (o *jobOutput) streams() (stdout, stderr string, dropped int64) {
+	chunks, dropped, _, _, _ := o.since(0)
+	var out, errOut []byte
+	for _, c := range chunks {
+		if c.stream == eventStderr {
+			errOut = append(errOut, c.data...)
+		} else {
+			out = append(out, c.data...)
+		}
+	}
+	return string(out), string(errOut), dropped
+}
+
+type streamWriter struct {
+	output *jobOutput
+	stream string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.output.write(w.stream, p)
+	return len(p), nil
+}
@@ -0,0 +1,192 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+func jobManager(r *http.Request) *JobManager {
+	m, _ := r.Context().Value(types.JobsKey).(*JobManager)
+	return m
+}
+
+// lookupJob returns the job of the {id} path variable, or replies an error
+func lookupJob(w http.ResponseWriter, r *http.Request) (*job, bool) {
+	m := jobManager(r)
+	if m == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrJobManagerNull)
+		return nil, false
+	}
+
+	id := mux.Vars(r)["id"]
+	j, ok := m.get(id)
+	if !ok {
+		utils.Error(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrJobNotFound, id))
+		return nil, false
+	}
+	return j, true
+}
+
+// CreateJob starts a command in background, the body is the same as /exec. The job keeps running
+// after the request ends, it is limited by the max jobs instead of the exec sessions.
+func CreateJob(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request POST /jobs")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+	m := jobManager(r)
+	if m == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrJobManagerNull)
+		return
+	}
+
+	var body execBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	j, err := m.start(mc, &body)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrJobsSaturated):
+			w.Header().Set("Retry-After", "1")
+			utils.Error(w, http.StatusTooManyRequests, err)
+		case errors.Is(err, errJobsShutdown):
+			utils.Error(w, http.StatusServiceUnavailable, err)
+		default:
+			utils.Error(w, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, j.info(false))
+}
+
+// ListJobs returns all the jobs without their output
+func ListJobs(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("Request GET /jobs")
+
+	m := jobManager(r)
+	if m == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrJobManagerNull)
+		return
+	}
+
+	infos := make([]*jobInfo, 0)
+	for _, j := range m.list() {
+		infos = append(infos, j.info(false))
+	}
+	utils.WriteJSON(w, http.StatusOK, infos)
+}
+
+// GetJob returns a job with its buffered output
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("Request GET /jobs/{id}")
+
+	j, ok := lookupJob(w, r)
+	if !ok {
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, j.info(true))
+}
+
+// DeleteJob signals a running job, SIGKILL by default or the one of ?signal=, e.g. TERM.
+// A job killed by SIGKILL ends in the cancelled state. A finished job is removed.
+func DeleteJob(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request DELETE /jobs/{id}")
+
+	j, ok := lookupJob(w, r)
+	if !ok {
+		return
+	}
+
+	if !j.running() {
+		jobManager(r).remove(j.id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	sig := strings.TrimPrefix(strings.ToUpper(r.URL.Query().Get("signal")), "SIG")
+	if sig == "" || sig == string(ssh.SIGKILL) {
+		// the session is closed as well, in case the guest sshd does not support signals
+		j.cancel(errJobCanceled)
+	} else if err := j.cmd.Signal(ssh.Signal(sig)); err != nil {
+		utils.Error(w, http.StatusConflict, fmt.Errorf("send signal %s to job %s failed: %w", sig, j.id, err))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, j.info(false))
+}
+
+// JobLogs streams the output of a job as server-sent events, the same events as /exec.
+// Without ?follow=1 the stream ends with the output buffered so far.
+func JobLogs(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("Request GET /jobs/{id}/logs")
+
+	j, ok := lookupJob(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		utils.Error(w, http.StatusInternalServerError, ErrStreamNotSupport)
+		return
+	}
+	follow := r.URL.Query().Get("follow")
+	following := follow == "1" || follow == "true"
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	var offset int64
+	for {
+		chunks, dropped, next, changed, closed := j.output.since(offset)
+		if dropped > 0 {
+			writeSSE(w, sseEvent{name: eventDropped, data: fmt.Sprint(dropped)})
+		}
+		for _, c := range chunks {
+			writeSSE(w, sseEvent{name: c.stream, data: string(c.data)})
+		}
+		offset = next
+
+		if closed || !following {
+			break
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-time.After(3 * time.Second): //nolint:mnd
+			_, _ = fmt.Fprintf(w, ": ping\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}
+
+	if info := j.info(false); info.Exit != nil {
+		b, _ := json.Marshal(info.Exit)
+		writeSSE(w, sseEvent{name: eventExit, data: string(b)})
+	} else if info.Error != "" {
+		writeSSE(w, sseEvent{name: eventError, data: info.Error})
+	}
+	writeSSE(w, sseEvent{name: eventDone, data: "done"})
+}
@@ -0,0 +1,150 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/vmconfig"
+)
+
+var ErrExecSaturated = errors.New("too many exec sessions")
+
+// ExecLimiter caps the commands run by the rest api in guest. The requests above the cap wait in a
+// FIFO queue, so a flood of requests can not starve the earlier ones.
+type ExecLimiter struct {
+	mu      sync.Mutex
+	limits  vmconfig.ExecLimits
+	running int
+	// waiting holds a chan struct{} for each queued request, it is closed when the session is handed over
+	waiting *list.List
+}
+
+type ExecLimiterStats struct {
+	Running     int `json:"running"`
+	Queued      int `json:"queued"`
+	MaxSessions int `json:"maxSessions"`
+	QueueLength int `json:"queueLength"`
+}
+
+func NewExecLimiter(limits vmconfig.ExecLimits) *ExecLimiter {
+	return &ExecLimiter{
+		limits:  limits.WithDefaults(),
+		waiting: list.New(),
+	}
+}
+
+func (l *ExecLimiter) saturated(format string, a ...any) error {
+	return fmt.Errorf("%w: %s (max sessions %d, queue length %d)",
+		ErrExecSaturated, fmt.Sprintf(format, a...), l.limits.MaxSessions, l.limits.QueueLength)
+}
+
+// acquire waits for a free session, release must be called once the command finished.
+// ErrExecSaturated is returned if the queue is full or the queue timeout is reached.
+func (l *ExecLimiter) acquire(ctx context.Context) (release func(), err error) {
+	l.mu.Lock()
+	if l.running < l.limits.MaxSessions && l.waiting.Len() == 0 {
+		l.running++
+		l.mu.Unlock()
+		return l.releaseOnce(), nil
+	}
+	if l.waiting.Len() >= l.limits.QueueLength {
+		l.mu.Unlock()
+		return nil, l.saturated("queue is full")
+	}
+	ready := make(chan struct{})
+	elem := l.waiting.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Duration(l.limits.QueueTimeout) * time.Second)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return l.releaseOnce(), nil
+	case <-timer.C:
+		err = l.saturated("waited %ds for a session", l.limits.QueueTimeout)
+	case <-ctx.Done():
+		err = context.Cause(ctx)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// the session was handed over while giving up, pass it on
+		l.releaseLocked()
+	default:
+		l.waiting.Remove(elem)
+	}
+	return nil, err
+}
+
+func (l *ExecLimiter) releaseOnce() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.releaseLocked()
+		})
+	}
+}
+
+// releaseLocked hands the session over to the first waiting request, l.mu must be held
+func (l *ExecLimiter) releaseLocked() {
+	if front := l.waiting.Front(); front != nil {
+		l.waiting.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	l.running--
+}
+
+func (l *ExecLimiter) Stats() ExecLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return ExecLimiterStats{
+		Running:     l.running,
+		Queued:      l.waiting.Len(),
+		MaxSessions: l.limits.MaxSessions,
+		QueueLength: l.limits.QueueLength,
+	}
+}
+
+func execLimiter(r *http.Request) *ExecLimiter {
+	l, _ := r.Context().Value(types.ExecLimiterKey).(*ExecLimiter)
+	return l
+}
+
+// acquireExec takes a session of the request's limiter, or replies an error.
+// 429 is replied when the limiter is saturated.
+func acquireExec(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	l := execLimiter(r)
+	if l == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrExecLimiterNull)
+		return nil, false
+	}
+
+	release, err := l.acquire(r.Context())
+	if err != nil {
+		if errors.Is(err, ErrExecSaturated) {
+			w.Header().Set("Retry-After", "1")
+			utils.Error(w, http.StatusTooManyRequests, err)
+		} else {
+			utils.Error(w, http.StatusServiceUnavailable, err)
+		}
+		return nil, false
+	}
+	return release, true
+}
@@ -0,0 +1,102 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/sirupsen/logrus"
+)
+
+type logLevelBody struct {
+	Level string `json:"level"`
+	// GvproxyDebug is left unchanged if absent
+	GvproxyDebug *bool `json:"gvproxyDebug"`
+}
+
+type logLevelResp struct {
+	Level        string `json:"level"`
+	GvproxyDebug bool   `json:"gvproxyDebug"`
+	// RestartRequired is set if gvproxyDebug changed, gvproxy only reads it when it starts
+	RestartRequired bool `json:"restartRequired"`
+}
+
+// GetLogLevel returns the current log level and gvproxy debug mode
+func GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request /loglevel")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	mc.Lock()
+	defer mc.Unlock()
+	utils.WriteJSON(w, http.StatusOK, &logLevelResp{
+		Level:        logrus.GetLevel().String(),
+		GvproxyDebug: mc.GvproxyDebug,
+	})
+}
+
+// UpdateLogLevel changes the log level of the running process, the level is not persisted.
+//
+// The gvproxy debug mode does not apply to the running VM: gvproxy only takes -debug on its command line and
+// has no api to change the verbosity, so the mode is saved in the machine config and takes effect on the next
+// start. restartRequired is set in the response when it changed.
+func UpdateLogLevel(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request PUT /loglevel")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	var body logLevelBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	level := logrus.GetLevel()
+	if body.Level != "" {
+		var err error
+		if level, err = logrus.ParseLevel(body.Level); err != nil {
+			utils.Error(w, http.StatusBadRequest, fmt.Errorf("invalid log level: %w", err))
+			return
+		}
+	}
+
+	mc.Lock()
+	defer mc.Unlock()
+
+	restart := false
+	if body.GvproxyDebug != nil && *body.GvproxyDebug != mc.GvproxyDebug {
+		mc.GvproxyDebug = *body.GvproxyDebug
+		if err := mc.Write(); err != nil {
+			mc.GvproxyDebug = !mc.GvproxyDebug
+			utils.Error(w, http.StatusInternalServerError, fmt.Errorf("save machine config failed: %w", err))
+			return
+		}
+		restart = true
+	}
+
+	if level != logrus.GetLevel() {
+		logrus.Infof("change log level from %s to %s", logrus.GetLevel(), level)
+		logrus.SetLevel(level)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, &logLevelResp{
+		Level:           level.String(),
+		GvproxyDebug:    mc.GvproxyDebug,
+		RestartRequired: restart,
+	})
+}
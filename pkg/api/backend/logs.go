@@ -0,0 +1,119 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTailLines = 200
+	maxTailLines     = 10000
+	// tailChunk is the size read backwards from the end of the file while looking for line breaks
+	tailChunk = 64 << 10
+)
+
+// logFiles are the log files which can be read by /logs/{name}
+var logFiles = map[string]string{
+	"ovm":     define.LogFileName,
+	"gvproxy": define.GvProxyLogName,
+	"vmm":     define.VMMLogName,
+	"console": define.ConsoleLogName,
+}
+
+// tailFile returns the last n lines of file
+func tailFile(file string, n int) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	defer f.Close() //nolint:errcheck
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	var buf []byte
+	offset := info.Size()
+	for offset > 0 {
+		size := min(offset, tailChunk)
+		offset -= size
+		chunk := make([]byte, size)
+		if _, err := f.ReadAt(chunk, offset); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err //nolint:wrapcheck
+		}
+		buf = append(chunk, buf...)
+
+		// the trailing line break does not start a line
+		if bytes.Count(bytes.TrimSuffix(buf, []byte("\n")), []byte("\n")) >= n {
+			break
+		}
+	}
+
+	lines := bytes.SplitAfter(buf, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return bytes.Join(lines, nil), nil
+}
+
+// TailLog replies the last ?tail= lines (default 200) of the log file {name}: ovm, gvproxy, vmm or console
+func TailLog(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request /logs")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	logName, ok := logFiles[name]
+	if !ok {
+		utils.Error(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrLogNotFound, name))
+		return
+	}
+
+	n := defaultTailLines
+	if v := r.URL.Query().Get("tail"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 || n > maxTailLines {
+			utils.Error(w, http.StatusBadRequest, fmt.Errorf("invalid tail %q, expect 1 to %d", v, maxTailLines))
+			return
+		}
+	}
+
+	content, err := tailFile(mc.GetLogPath(logName), n)
+	if errors.Is(err, fs.ErrNotExist) {
+		utils.Error(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrLogNotFound, name))
+		return
+	}
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
@@ -0,0 +1,168 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/gvproxy"
+	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/port"
+
+	"github.com/sirupsen/logrus"
+)
+
+type portForwardInfo struct {
+	vmconfig.PortForward
+	// Active is set if gvproxy is serving the forward, it is omitted if gvproxy can not be reached
+	Active *bool `json:"active,omitempty"`
+}
+
+func findPortForward(mc *vmconfig.MachineConfig, pf vmconfig.PortForward) int {
+	return slices.IndexFunc(mc.PortForwards, func(p vmconfig.PortForward) bool {
+		return p.Local() == pf.Local() && p.Protocol == pf.Protocol
+	})
+}
+
+// ListPorts returns the port forwards of the machine
+func ListPorts(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("Request GET /ports")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	mc.Lock()
+	defer mc.Unlock()
+
+	active, err := gvproxy.ActiveForwards(mc)
+	if err != nil {
+		logrus.Warnf("%v", err)
+	}
+
+	infos := make([]portForwardInfo, 0, len(mc.PortForwards))
+	for _, pf := range mc.PortForwards {
+		info := portForwardInfo{PortForward: pf}
+		if active != nil {
+			ok := active[gvproxy.ForwardKey(pf.Local(), pf.Protocol)]
+			info.Active = &ok
+		}
+		infos = append(infos, info)
+	}
+	utils.WriteJSON(w, http.StatusOK, infos)
+}
+
+// AddPort exposes a guest port on the host and saves the forward, so it is re-applied on the next start.
+// Body: {"guestPort": 8080, "hostPort": 8080, "hostIP": "127.0.0.1", "protocol": "tcp"}, only guestPort
+// is required. 409 is replied if the host port is in use.
+func AddPort(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request POST /ports")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	var pf vmconfig.PortForward
+	if err := json.NewDecoder(r.Body).Decode(&pf); err != nil {
+		utils.Error(w, http.StatusBadRequest, fmt.Errorf("decode request body failed: %w", err))
+		return
+	}
+	pf.SetDefaults()
+	if err := pf.Validate(); err != nil {
+		utils.Error(w, http.StatusBadRequest, fmt.Errorf("invalid port forward: %w", err))
+		return
+	}
+
+	mc.Lock()
+	defer mc.Unlock()
+
+	if findPortForward(mc, pf) >= 0 {
+		utils.Error(w, http.StatusConflict, fmt.Errorf("%w: %s/%s is already forwarded", ErrPortConflict, pf.Local(), pf.Protocol))
+		return
+	}
+	if pf.Protocol == "tcp" && port.IsListening(pf.HostPort) {
+		utils.Error(w, http.StatusConflict, fmt.Errorf("%w: host port %d is in use", ErrPortConflict, pf.HostPort))
+		return
+	}
+
+	if err := gvproxy.Expose(mc, pf); err != nil {
+		code := http.StatusBadGateway
+		if strings.Contains(err.Error(), "address already in use") {
+			code = http.StatusConflict
+			err = fmt.Errorf("%w: %w", ErrPortConflict, err)
+		}
+		utils.Error(w, code, err)
+		return
+	}
+
+	mc.PortForwards = append(mc.PortForwards, pf)
+	if err := mc.Write(); err != nil {
+		utils.Error(w, http.StatusInternalServerError, fmt.Errorf("save machine config failed: %w", err))
+		return
+	}
+
+	logrus.Infof("port forward %s/%s -> %s added", pf.Local(), pf.Protocol, pf.Remote())
+	utils.WriteJSON(w, http.StatusCreated, pf)
+}
+
+// RemovePort stops and removes a port forward. Query: hostPort, hostIP (default 127.0.0.1), protocol (default tcp)
+func RemovePort(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request DELETE /ports")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	q := r.URL.Query()
+	hostPort, err := strconv.Atoi(q.Get("hostPort"))
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, fmt.Errorf("invalid hostPort %q", q.Get("hostPort")))
+		return
+	}
+	pf := vmconfig.PortForward{
+		Protocol: q.Get("protocol"),
+		HostIP:   q.Get("hostIP"),
+		HostPort: hostPort,
+	}
+	pf.SetDefaults()
+
+	mc.Lock()
+	defer mc.Unlock()
+
+	i := findPortForward(mc, pf)
+	if i < 0 {
+		utils.Error(w, http.StatusNotFound, fmt.Errorf("%w: %s/%s", ErrPortNotFound, pf.Local(), pf.Protocol))
+		return
+	}
+
+	// a forward which failed to be re-applied is not served by gvproxy, only remove it from the config
+	if active, err := gvproxy.ActiveForwards(mc); err != nil || active[gvproxy.ForwardKey(pf.Local(), pf.Protocol)] {
+		if err := gvproxy.Unexpose(mc, mc.PortForwards[i]); err != nil {
+			utils.Error(w, http.StatusBadGateway, err)
+			return
+		}
+	}
+
+	mc.PortForwards = slices.Delete(mc.PortForwards, i, i+1)
+	if err := mc.Write(); err != nil {
+		utils.Error(w, http.StatusInternalServerError, fmt.Errorf("save machine config failed: %w", err))
+		return
+	}
+
+	logrus.Infof("port forward %s/%s removed", pf.Local(), pf.Protocol)
+	w.WriteHeader(http.StatusNoContent)
+}
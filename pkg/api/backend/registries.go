@@ -0,0 +1,94 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/ignition"
+	"bauklotze/pkg/machine/ssh/service"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/sirupsen/logrus"
+)
+
+// applyRegistriesMu serializes UpdateRegistries, from writing the guest files to saving the config
+var applyRegistriesMu sync.Mutex
+
+// GetRegistries returns the registries configuration of the guest
+func GetRegistries(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request /registries")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	mc.Lock()
+	defer mc.Unlock()
+	utils.WriteJSON(w, http.StatusOK, mc.Registries)
+}
+
+// UpdateRegistries replaces the registries configuration and applies it to the running guest,
+// podman reads the files on every invocation so no reboot is needed
+func UpdateRegistries(w http.ResponseWriter, r *http.Request) {
+	logrus.Infof("Request PUT /registries")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	var cfg vmconfig.RegistriesConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		utils.Error(w, http.StatusBadRequest, fmt.Errorf("decode request body failed: %w", err))
+		return
+	}
+
+	if err := cfg.Validate(); err != nil {
+		utils.Error(w, http.StatusBadRequest, fmt.Errorf("invalid registries config: %w", err))
+		return
+	}
+
+	files, err := ignition.RegistriesFiles(cfg)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the files are applied over ssh without the machine config lock, which guards the other handlers and
+	// the provision hooks. applyRegistriesMu keeps concurrent updates from mixing their files in guest
+	applyRegistriesMu.Lock()
+	defer applyRegistriesMu.Unlock()
+
+	for _, f := range files {
+		if f.Content == nil {
+			err = service.RemoveFiles(r.Context(), mc, f.Path)
+		} else {
+			err = service.WriteFile(r.Context(), mc, f.Path, f.Content, f.Perm)
+		}
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, fmt.Errorf("%w: %w", ErrApplyRegistries, err))
+			return
+		}
+	}
+
+	mc.Lock()
+	mc.Registries = cfg
+	err = mc.Write()
+	mc.Unlock()
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, fmt.Errorf("save machine config failed: %w", err))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, cfg)
+}
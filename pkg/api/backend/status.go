@@ -0,0 +1,46 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package backend
+
+import (
+	"net/http"
+
+	"bauklotze/pkg/api/types"
+	"bauklotze/pkg/api/utils"
+	"bauklotze/pkg/machine/ssh/service"
+	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/ssh"
+
+	"github.com/sirupsen/logrus"
+)
+
+type StatusResp struct {
+	SSH  ssh.Health        `json:"ssh"`
+	Exec *ExecLimiterStats `json:"exec,omitempty"`
+	Jobs *JobManagerStats  `json:"jobs,omitempty"`
+}
+
+// GetStatus returns the runtime state of the VM
+func GetStatus(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("Request /status")
+
+	mc := r.Context().Value(types.McKey).(*vmconfig.MachineConfig)
+	if mc == nil {
+		utils.Error(w, http.StatusInternalServerError, ErrMachineConfigNull)
+		return
+	}
+
+	resp := &StatusResp{
+		SSH: service.GuestSSHHealth(mc),
+	}
+	if l := execLimiter(r); l != nil {
+		stats := l.Stats()
+		resp.Exec = &stats
+	}
+	if m := jobManager(r); m != nil {
+		stats := m.Stats()
+		resp.Jobs = &stats
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
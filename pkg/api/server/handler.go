@@ -24,6 +24,10 @@ func PanicHandler() mux.MiddlewareFunc {
 			// http.Server hides panics from handlers, we want to record them and fix the cause
 			defer func() {
 				if err := recover(); err != nil {
+					// the handler aborts the response on purpose, e.g. a short download
+					if err == http.ErrAbortHandler { //nolint:errorlint
+						panic(err)
+					}
 					buf := make([]byte, 1<<20) //nolint:mnd
 					n := runtime.Stack(buf, true)
 					logrus.Warnf("Recovering from API service endpoint handler panic: %v, %s", err, buf[:n])
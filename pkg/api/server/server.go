@@ -26,6 +26,8 @@ import (
 type APIServer struct {
 	Server   http.Server
 	Listener net.Listener
+	// jobs are canceled when the server stops
+	jobs *backend.JobManager
 }
 
 func RestService(ctx context.Context, mc *vmconfig.MachineConfig, endPoint string) error {
@@ -90,16 +92,23 @@ func (s *APIServer) Serve() error {
 func makeNewServer(mc *vmconfig.MachineConfig, listener net.Listener) *APIServer {
 	router := mux.NewRouter().UseEncodedPath()
 
+	// background jobs live as long as the server
+	jobs := backend.NewJobManager(mc.Exec)
+
 	server := APIServer{
 		Server: http.Server{
 			Handler: router, // Mux
 		},
 		Listener: listener,
+		jobs:     jobs,
 	}
 
+	limiter := backend.NewExecLimiter(mc.Exec)
 	server.Server.BaseContext = func(l net.Listener) context.Context {
 		// Every request will have access to the machineConfig,this is a way to pass the machineConfig to the handlers
 		ctx := context.WithValue(context.Background(), types.McKey, mc)
+		ctx = context.WithValue(ctx, types.JobsKey, jobs)
+		ctx = context.WithValue(ctx, types.ExecLimiterKey, limiter)
 		return ctx
 	}
 
@@ -134,17 +143,43 @@ func makeNewServer(mc *vmconfig.MachineConfig, listener net.Listener) *APIServer
 func (s *APIServer) Shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
+	if err := s.jobs.Shutdown(ctx); err != nil {
+		logrus.Warnf("error when stopping jobs: %s", err)
+	}
 	return s.Server.Shutdown(ctx) //nolint:wrapcheck
 }
 
-// Close immediately stops responding to clients and exits
+// Close immediately stops responding to clients and exits, the running jobs are canceled
 func (s *APIServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	_ = s.jobs.Shutdown(ctx)
 	return s.Server.Close() //nolint:wrapcheck
 }
 
 func (s *APIServer) setupRouter(r *mux.Router) *mux.Router {
 	r.Handle("/info", s.APIHandler(backend.GetInfos)).Methods(http.MethodGet)
+	r.Handle("/status", s.APIHandler(backend.GetStatus)).Methods(http.MethodGet)
 	r.Handle("/exec", s.APIHandler(backend.DoExec)).Methods(http.MethodPost)
+	r.Handle("/exec/attach", s.APIHandler(backend.AttachExec)).Methods(http.MethodGet)
+	r.Handle("/jobs", s.APIHandler(backend.CreateJob)).Methods(http.MethodPost)
+	r.Handle("/jobs", s.APIHandler(backend.ListJobs)).Methods(http.MethodGet)
+	r.Handle("/jobs/{id}", s.APIHandler(backend.GetJob)).Methods(http.MethodGet)
+	r.Handle("/jobs/{id}", s.APIHandler(backend.DeleteJob)).Methods(http.MethodDelete)
+	r.Handle("/jobs/{id}/logs", s.APIHandler(backend.JobLogs)).Methods(http.MethodGet)
+	r.Handle("/files", s.APIHandler(backend.UploadFile)).Methods(http.MethodPut)
+	r.Handle("/files", s.APIHandler(backend.DownloadFile)).Methods(http.MethodGet)
+	r.Handle("/ports", s.APIHandler(backend.ListPorts)).Methods(http.MethodGet)
+	r.Handle("/ports", s.APIHandler(backend.AddPort)).Methods(http.MethodPost)
+	r.Handle("/ports", s.APIHandler(backend.RemovePort)).Methods(http.MethodDelete)
+	r.Handle("/logs/{name}", s.APIHandler(backend.TailLog)).Methods(http.MethodGet)
+	r.Handle("/loglevel", s.APIHandler(backend.GetLogLevel)).Methods(http.MethodGet)
+	r.Handle("/loglevel", s.APIHandler(backend.UpdateLogLevel)).Methods(http.MethodPut)
+	r.Handle("/console", s.APIHandler(backend.GetConsole)).Methods(http.MethodGet)
+	r.Handle("/diagnose", s.APIHandler(backend.Diagnose)).Methods(http.MethodGet)
 	r.Handle("/stop", s.APIHandler(backend.StopVM)).Methods(http.MethodPost)
+	r.Handle("/ignition", s.APIHandler(backend.RenderIgnition)).Methods(http.MethodGet)
+	r.Handle("/registries", s.APIHandler(backend.GetRegistries)).Methods(http.MethodGet)
+	r.Handle("/registries", s.APIHandler(backend.UpdateRegistries)).Methods(http.MethodPut)
 	return r
 }
@@ -7,4 +7,6 @@ type APIContextKey int
 
 const (
 	McKey APIContextKey = iota
+	JobsKey
+	ExecLimiterKey
 )
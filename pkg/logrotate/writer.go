@@ -0,0 +1,298 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package logrotate
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxSize    = 10 << 20
+	DefaultMaxBackups = 5
+	DefaultMaxAge     = 7 * 24 * time.Hour
+
+	compressSuffix = ".gz"
+)
+
+// Options controls when a log file is rotated and how long the rotated files are kept
+type Options struct {
+	// MaxSize is the size in bytes which triggers the rotation, zero means never rotate by size
+	MaxSize int64
+	// MaxAge rotates the current file once its first write is older than MaxAge, and removes the rotated
+	// files older than MaxAge. Zero means no age limit
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept as path.1 ... path.N, path.1 is the newest
+	MaxBackups int
+	// Compress gzips the rotated files into path.N.gz
+	Compress bool
+}
+
+// DefaultOptions is used by all the log files of a machine
+func DefaultOptions() Options {
+	return Options{
+		MaxSize:    DefaultMaxSize,
+		MaxAge:     DefaultMaxAge,
+		MaxBackups: DefaultMaxBackups,
+		Compress:   true,
+	}
+}
+
+// Writer appends to a log file and rotates it by size and age. It is safe for concurrent use.
+type Writer struct {
+	path string
+	opts Options
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	// born is the time of the first write into the current file, zero if it is empty
+	born time.Time
+
+	// millMu serializes the renames of the rotated files with their background compression
+	millMu sync.Mutex
+}
+
+// New opens path for appending, the directory of path is created if missing
+func New(path string, opts Options) (*Writer, error) {
+	w := &Writer{path: path, opts: opts}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil { //nolint:mnd
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	// the leftovers of the previous runs, e.g. an interrupted compression or a smaller MaxBackups
+	go w.mill()
+	return w, nil
+}
+
+// Path returns the path of the current log file
+func (w *Writer) Path() string {
+	return w.path
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644) //nolint:mnd
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	w.file, w.size, w.born = f, info.Size(), time.Time{}
+	// the creation time is not portable, the mtime is the closest guess of an existing file
+	if w.size > 0 {
+		w.born = info.ModTime()
+	}
+	return nil
+}
+
+func (w *Writer) shouldRotate(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.opts.MaxSize > 0 && w.size+int64(n) > w.opts.MaxSize {
+		return true
+	}
+	return w.opts.MaxAge > 0 && time.Since(w.born) > w.opts.MaxAge
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, fs.ErrClosed
+	}
+
+	// a single write is never split, so a line is not torn across two files
+	if w.shouldRotate(len(p)) {
+		// keep writing into the reopened file if only the renames failed, losing the log is worse
+		if err := w.rotate(); err != nil && w.file == nil {
+			return 0, err
+		}
+	}
+
+	if w.size == 0 {
+		w.born = time.Now()
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err //nolint:wrapcheck
+}
+
+// Rotate moves the current log file to path.1 and starts a new one
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return fs.ErrClosed
+	}
+	return w.rotate()
+}
+
+// rotate renames the log files and reopens path, path is reopened even if a rename fails
+func (w *Writer) rotate() error {
+	_ = w.file.Close()
+	w.file = nil
+
+	renameErr := w.shift()
+	if err := w.open(); err != nil {
+		return err
+	}
+	go w.mill()
+	return renameErr
+}
+
+// shift moves path.N-1 to path.N, ..., path to path.1, the oldest one is overwritten
+func (w *Writer) shift() error {
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+
+	if w.opts.MaxBackups <= 0 {
+		if err := os.Remove(w.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove log file: %w", err)
+		}
+		return nil
+	}
+
+	for i := w.opts.MaxBackups; i > 1; i-- {
+		// a backup is either compressed or not, the missing one is skipped
+		for _, suffix := range []string{"", compressSuffix} {
+			err := os.Rename(w.backupName(i-1)+suffix, w.backupName(i)+suffix)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to shift rotated log file: %w", err)
+			}
+		}
+	}
+	// path.1 can not be both compressed and not
+	_ = os.Remove(w.backupName(1) + compressSuffix)
+
+	if err := os.Rename(w.path, w.backupName(1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) backupName(i int) string {
+	return w.path + "." + strconv.Itoa(i)
+}
+
+type backup struct {
+	path    string
+	index   int
+	modTime time.Time
+}
+
+// backups lists the rotated files of path
+func (w *Writer) backups() ([]backup, error) {
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	var list []backup
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, w.path+"."), compressSuffix)
+		i, err := strconv.Atoi(suffix)
+		if err != nil || i <= 0 {
+			continue
+		}
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		list = append(list, backup{path: m, index: i, modTime: info.ModTime()})
+	}
+	return list, nil
+}
+
+// mill removes the rotated files beyond MaxBackups or older than MaxAge, and compresses the rest if
+// Compress is set. Errors are ignored, the log file itself may be the only place to report them.
+func (w *Writer) mill() {
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+
+	list, err := w.backups()
+	if err != nil {
+		return
+	}
+
+	for _, b := range list {
+		expired := w.opts.MaxAge > 0 && time.Since(b.modTime) > w.opts.MaxAge
+		if b.index > w.opts.MaxBackups || expired {
+			_ = os.Remove(b.path)
+			continue
+		}
+		if w.opts.Compress && !strings.HasSuffix(b.path, compressSuffix) {
+			_ = compressFile(b.path, b.path+compressSuffix)
+		}
+	}
+}
+
+// compressFile gzips src into dst and removes src, dst is written through a temporary file
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer in.Close() //nolint:errcheck
+
+	info, err := in.Stat()
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer os.Remove(tmp) //nolint:errcheck
+
+	gw := gzip.NewWriter(out)
+	_, err = io.Copy(gw, in)
+	if closeErr := gw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	// keep the mtime, MaxAge is checked against it
+	_ = os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	if err := os.Rename(tmp, dst); err != nil {
+		return err //nolint:wrapcheck
+	}
+	return os.Remove(src) //nolint:wrapcheck
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err //nolint:wrapcheck
+}
@@ -0,0 +1,98 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package console
+
+import (
+	"bytes"
+	"sync"
+)
+
+// DefaultSize is the size of the guest console output kept in memory, console.log keeps more on disk
+const DefaultSize = 256 << 10
+
+// Output keeps the tail of the guest console of the running machine
+var Output = NewRing(DefaultSize)
+
+// Ring keeps the last size bytes written into it, and wakes up the followers on every write.
+// Offsets count all the bytes ever written, so a follower can tell how many bytes it missed.
+type Ring struct {
+	mu   sync.Mutex
+	size int
+	// buf grows up to twice the size before it is trimmed, so a write does not always move the content
+	buf   []byte
+	total int64
+	// changed is closed and replaced on every write
+	changed chan struct{}
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{
+		size:    size,
+		changed: make(chan struct{}),
+	}
+}
+
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	r.buf = append(r.buf, p...)
+	if len(r.buf) > 2*r.size {
+		n := copy(r.buf, r.buf[len(r.buf)-r.size:])
+		r.buf = r.buf[:n]
+	}
+	r.total += int64(len(p))
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// window returns the kept content and the offset of its first byte
+func (r *Ring) window() ([]byte, int64) {
+	keep := min(len(r.buf), r.size)
+	return r.buf[len(r.buf)-keep:], r.total - int64(keep)
+}
+
+// Snapshot returns a copy of the kept content and the offset after its last byte
+func (r *Ring) Snapshot() ([]byte, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	content, _ := r.window()
+	return bytes.Clone(content), r.total
+}
+
+// Since returns the content written after offset, the offset after it, and the number of bytes which are
+// no longer kept. changed is closed on the next write.
+func (r *Ring) Since(offset int64) (content []byte, next int64, dropped int64, changed <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	window, start := r.window()
+	if offset < start {
+		dropped = start - offset
+		offset = start
+	}
+	offset = min(offset, r.total)
+
+	content = bytes.Clone(window[offset-start:])
+	return content, r.total, dropped, r.changed
+}
+
+// Tail returns the last n lines of the kept content
+func (r *Ring) Tail(n int) string {
+	content, _ := r.Snapshot()
+	content = bytes.TrimRight(bytes.ReplaceAll(content, []byte("\r"), nil), "\n")
+
+	lines := bytes.Split(content, []byte("\n"))
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return string(bytes.Join(lines, []byte("\n")))
+}
@@ -17,6 +17,7 @@ const (
 	DataPrefixDir   = "data"
 	SocksPrefixDir  = "socks"
 	PidsPrefixDir   = "pids"
+	IgnPrefixDir    = "ignition"
 
 	DefaultIdentityName = "sshkey"
 
@@ -24,6 +25,12 @@ const (
 	GvProxyPidName    = "gvproxy.pid"
 	GvProxyLogName    = "gvproxy.log"
 	GvProxyEndPoint   = "gvproxy.sock"
+	// GvProxyServices is the socket of the gvproxy services api, e.g. the port forwarder
+	GvProxyServices = "gvproxy-services.sock"
+
+	// VMMLogName receives the log of vfkit/krunkit, ConsoleLogName receives the guest console
+	VMMLogName     = "vmm.log"
+	ConsoleLogName = "console.log"
 
 	KrunkitPidFile = "krunkit.pid"
 	VFkitPidFile   = "vfkit.pid"
@@ -35,20 +42,34 @@ const (
 	RESTAPIEndpointName = "ovm_restapi.socks"
 
 	LocalHostURL = "127.0.0.1"
+	// HostIPInGuest is the address gvproxy translates to the host's 127.0.0.1
+	HostIPInGuest = "192.168.127.254"
+	// GuestIP is the address of the guest in the gvproxy network
+	GuestIP = "192.168.127.2"
 
 	DefaultSSHPort = 61234
 
 	PodmanHostSocksName = "podman-api.sock"
+	// DockerHostSocksName is the optional docker compatible socket, it is served by the guest podman
+	DockerHostSocksName = "docker.sock"
 	PodmanGuestSocks    = "/run/podman/podman.sock"
 
-	SSHKey = "sshkey"
+	SSHKey     = "sshkey"
+	SSHHostKey = "ssh_host_ed25519_key"
 
-	IgnMnt               = "/tmp/initfs:/tmp/initfs"
+	// IgnGuestDir is where the guest finds the ignition script, the per-machine ignition dir is shared to it
+	IgnGuestDir          = "/tmp/initfs"
+	IgnScriptName        = "ovm_ign.sh"
+	ProvisionDirName     = "provision.d"
+	ProvisionStatusDir   = "provision-status"
 	SSHAuthLocalSockName = "oo-ssh-agent-host.sock"
 	VMConfigJson         = "config.json"
 
 	LogOutFile     = "file"
 	LogOutTerminal = "terminal"
+
+	LogFormatText = "text"
+	LogFormatJSON = "json"
 )
 
 var (
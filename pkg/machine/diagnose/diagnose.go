@@ -0,0 +1,358 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package diagnose
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"bauklotze/pkg/machine/console"
+	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/events"
+	"bauklotze/pkg/machine/ssh/service"
+	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/system"
+
+	"github.com/shirou/gopsutil/v4/host"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	guestCmdTimeout = 15 * time.Second
+	dialTimeout     = time.Second
+)
+
+// guestCommands are collected in guest/ of the bundle if the guest is reachable
+var guestCommands = []struct {
+	file   string
+	script string
+}{
+	{"uname.txt", "uname -a"},
+	{"dmesg.txt", "dmesg"},
+	{"podman-info.txt", "podman info"},
+	{"mounts.txt", "cat /proc/mounts"},
+	{"df.txt", "df -h"},
+}
+
+// FileName returns the default name of the bundle of mc
+func FileName(mc *vmconfig.MachineConfig) string {
+	return fmt.Sprintf("ovm-diagnose-%s-%s.tar.gz", mc.VMName, time.Now().Format("20060102-150405"))
+}
+
+// bundle writes the entries of the tarball, a failed collector is recorded in errors.txt rather than
+// failing the bundle, only the errors of the tar stream are returned
+type bundle struct {
+	tw     *tar.Writer
+	prefix string
+	now    time.Time
+	errs   []string
+}
+
+func (b *bundle) fail(what string, err error) {
+	logrus.Warnf("diagnose: collect %s failed: %v", what, err)
+	b.errs = append(b.errs, fmt.Sprintf("%s: %v", what, err))
+}
+
+func (b *bundle) add(name string, content []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:    b.prefix + name,
+		Mode:    0644, //nolint:mnd
+		Size:    int64(len(content)),
+		ModTime: modTime,
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write tar header of %s failed: %w", name, err)
+	}
+	if _, err := b.tw.Write(content); err != nil {
+		return fmt.Errorf("write %s failed: %w", name, err)
+	}
+	return nil
+}
+
+func (b *bundle) addJSON(name string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.fail(name, err)
+		return nil
+	}
+	return b.add(name, content, b.now)
+}
+
+// Write collects the support bundle of mc and writes it into w as a gzipped tarball. The console
+// buffer and the event history are those of the current process, they are only complete in the
+// start process.
+func Write(ctx context.Context, mc *vmconfig.MachineConfig, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	b := &bundle{
+		tw:     tar.NewWriter(gw),
+		prefix: strings.TrimSuffix(FileName(mc), ".tar.gz") + "/",
+		now:    time.Now(),
+	}
+
+	collectors := []func(context.Context, *vmconfig.MachineConfig) error{
+		b.hostInfo,
+		b.config,
+		b.logs,
+		b.console,
+		b.events,
+		b.processes,
+		b.sockets,
+		b.guest,
+	}
+	for _, collect := range collectors {
+		if err := collect(ctx, mc); err != nil {
+			return err
+		}
+	}
+
+	if len(b.errs) > 0 {
+		if err := b.add("errors.txt", []byte(strings.Join(b.errs, "\n")+"\n"), b.now); err != nil {
+			return err
+		}
+	}
+
+	if err := b.tw.Close(); err != nil {
+		return fmt.Errorf("close tar stream failed: %w", err)
+	}
+	return gw.Close() //nolint:wrapcheck
+}
+
+type hostInfo struct {
+	OS              string `json:"os"`
+	Arch            string `json:"arch"`
+	Platform        string `json:"platform,omitempty"`
+	PlatformVersion string `json:"platformVersion,omitempty"`
+	KernelVersion   string `json:"kernelVersion,omitempty"`
+	NumCPU          int    `json:"numCPU"`
+	GitCommit       string `json:"gitCommit"`
+	GoVersion       string `json:"goVersion"`
+	Time            string `json:"time"`
+}
+
+func (b *bundle) hostInfo(ctx context.Context, _ *vmconfig.MachineConfig) error {
+	info := hostInfo{
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		GitCommit: define.GitCommit,
+		GoVersion: runtime.Version(),
+		Time:      b.now.Format(time.RFC3339),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && info.GitCommit == "" {
+		info.GitCommit = bi.Main.Version
+	}
+
+	if hi, err := host.InfoWithContext(ctx); err != nil {
+		b.fail("host info", err)
+	} else {
+		info.Platform, info.PlatformVersion, info.KernelVersion = hi.Platform, hi.PlatformVersion, hi.KernelVersion
+	}
+	return b.addJSON("host.json", &info)
+}
+
+func (b *bundle) config(_ context.Context, mc *vmconfig.MachineConfig) error {
+	// the machine config is updated by the REST API and the provision hooks, marshal a consistent snapshot
+	mc.Lock()
+	raw, err := json.Marshal(mc)
+	mc.Unlock()
+	if err != nil {
+		b.fail("config.json", err)
+		return nil
+	}
+
+	snapshot := new(vmconfig.MachineConfig)
+	if err := json.Unmarshal(raw, snapshot); err != nil {
+		b.fail("config.json", err)
+		return nil
+	}
+	snapshot.Proxy = snapshot.Proxy.Redacted()
+	return b.addJSON("config.json", snapshot)
+}
+
+// logs adds all the files of the logs directory, including the rotated ones
+func (b *bundle) logs(_ context.Context, mc *vmconfig.MachineConfig) error {
+	entries, err := os.ReadDir(mc.Dirs.LogsDir)
+	if err != nil {
+		b.fail("logs", err)
+		return nil
+	}
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			b.fail(e.Name(), err)
+			continue
+		}
+		// the log file is being written, it is read as a whole so the header size matches
+		content, err := os.ReadFile(filepath.Join(mc.Dirs.LogsDir, e.Name()))
+		if err != nil {
+			b.fail(e.Name(), err)
+			continue
+		}
+		if err := b.add("logs/"+e.Name(), content, info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bundle) console(_ context.Context, _ *vmconfig.MachineConfig) error {
+	content, _ := console.Output.Snapshot()
+	if len(content) == 0 {
+		return nil
+	}
+	return b.add("console.txt", bytes.ReplaceAll(content, []byte("\r"), nil), b.now)
+}
+
+func (b *bundle) events(_ context.Context, _ *vmconfig.MachineConfig) error {
+	return b.addJSON("events.json", events.History())
+}
+
+type processState struct {
+	PidFile    string   `json:"pidFile"`
+	Pid        int32    `json:"pid,omitempty"`
+	Running    bool     `json:"running"`
+	Name       string   `json:"name,omitempty"`
+	Status     []string `json:"status,omitempty"`
+	Cmdline    []string `json:"cmdline,omitempty"`
+	CreateTime string   `json:"createTime,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func (b *bundle) processes(ctx context.Context, mc *vmconfig.MachineConfig) error {
+	var states []processState
+	for _, f := range []string{mc.PIDFiles.GvproxyPidFile, mc.PIDFiles.KrunKitPidFile, mc.PIDFiles.VFKitPidFile} {
+		if f == "" {
+			continue
+		}
+		state := processState{PidFile: f}
+		proc, err := system.FindProcessByPidFile(f)
+		if err != nil {
+			state.Error = err.Error()
+			states = append(states, state)
+			continue
+		}
+
+		state.Pid = proc.Pid
+		state.Running, _ = proc.IsRunningWithContext(ctx)
+		state.Name, _ = proc.NameWithContext(ctx)
+		state.Status, _ = proc.StatusWithContext(ctx)
+		state.Cmdline, _ = proc.CmdlineSliceWithContext(ctx)
+		if ms, err := proc.CreateTimeWithContext(ctx); err == nil {
+			state.CreateTime = time.UnixMilli(ms).Format(time.RFC3339)
+		}
+		states = append(states, state)
+	}
+	return b.addJSON("processes.json", states)
+}
+
+type socketState struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Exists   bool   `json:"exists"`
+	IsSocket bool   `json:"isSocket"`
+	// Accepting is set if a connection to the socket succeeds
+	Accepting bool   `json:"accepting"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (b *bundle) sockets(ctx context.Context, mc *vmconfig.MachineConfig) error {
+	socks := []struct{ name, path string }{
+		{"podman", mc.PodmanSocks.InHost},
+		{"docker", mc.DockerSocks},
+		{"restapi", mc.RestAPISocks},
+		{"gvproxy-network", mc.GetNetworkStackEndpoint()},
+		{"gvproxy-services", mc.GetGvproxyServicesEndpoint()},
+		{"ssh-auth", mc.SSHAuthSocks.LocalSocks},
+	}
+
+	var states []socketState
+	for _, s := range socks {
+		if s.path == "" {
+			continue
+		}
+		state := socketState{Name: s.name, Path: s.path}
+		info, err := os.Stat(s.path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			state.Error = err.Error()
+		default:
+			state.Exists = true
+			state.IsSocket = info.Mode()&fs.ModeSocket != 0
+		}
+
+		// the network endpoint of gvproxy is a datagram socket, dialing it as a stream fails
+		if state.IsSocket && s.name != "gvproxy-network" {
+			d := net.Dialer{Timeout: dialTimeout}
+			conn, err := d.DialContext(ctx, "unix", s.path)
+			if err != nil {
+				state.Error = err.Error()
+			} else {
+				state.Accepting = true
+				_ = conn.Close()
+			}
+		}
+		states = append(states, state)
+	}
+	return b.addJSON("sockets.json", states)
+}
+
+func runGuest(ctx context.Context, mc *vmconfig.MachineConfig, script string) ([]byte, error) {
+	myCmd, err := service.NewCmd(ctx, mc, "", nil)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	myCmd.SetShell(ctx, script)
+	myCmd.SetTimeout(guestCmdTimeout)
+
+	result, err := myCmd.Run()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	// the output is kept even if the command fails, e.g. dmesg without permission
+	out := append(result.Stdout, result.Stderr...)
+	if err := result.Err(); err != nil {
+		out = append(out, fmt.Sprintf("\n# %v\n", err)...)
+	}
+	return out, nil
+}
+
+// guest collects the state of the guest over ssh, it is skipped if the guest is not reachable
+func (b *bundle) guest(ctx context.Context, mc *vmconfig.MachineConfig) error {
+	for i, c := range guestCommands {
+		cmdCtx, cancel := context.WithTimeout(ctx, guestCmdTimeout)
+		out, err := runGuest(cmdCtx, mc, c.script)
+		cancel()
+		if err != nil {
+			b.fail("guest "+c.script, err)
+			// the first command tells if the guest is reachable at all
+			if i == 0 {
+				return nil
+			}
+			continue
+		}
+		if err := b.add("guest/"+c.file, out, b.now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package diagnose
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"bauklotze/pkg/machine/vmconfig"
+)
+
+const testPassword = "s3cr3t-pass"
+
+// bundleConfig runs the config collector and returns config.json of the tarball
+func bundleConfig(t *testing.T, mc *vmconfig.MachineConfig) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	b := &bundle{tw: tar.NewWriter(buf), now: time.Now()}
+	if err := b.config(context.Background(), mc); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.errs) > 0 {
+		t.Fatalf("collect config failed: %v", b.errs)
+	}
+	if err := b.tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "config.json" {
+		t.Fatalf("got entry %q, want config.json", hdr.Name)
+	}
+	content, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestConfigRedactsProxy(t *testing.T) {
+	tests := []struct {
+		name  string
+		proxy string
+		want  string
+	}{
+		{"url", "http://user:" + testPassword + "@127.0.0.1:7890", "http://" + vmconfig.RedactedMarker + "@127.0.0.1:7890"},
+		{"no scheme", "user:" + testPassword + "@proxy.example.com:3128", vmconfig.RedactedMarker + "@proxy.example.com:3128"},
+		{"unparseable", "http://user:" + testPassword + "@[proxy.example.com:3128", vmconfig.RedactedMarker},
+		{"no credentials", "proxy.example.com:3128", "proxy.example.com:3128"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := &vmconfig.MachineConfig{
+				VMName: "default",
+				Proxy:  vmconfig.ProxyConfig{HTTPProxy: tt.proxy, HTTPSProxy: tt.proxy},
+			}
+
+			content := bundleConfig(t, mc)
+			if strings.Contains(string(content), testPassword) {
+				t.Fatalf("config.json leaks the proxy password:\n%s", content)
+			}
+
+			var got vmconfig.MachineConfig
+			if err := json.Unmarshal(content, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Proxy.HTTPProxy != tt.want || got.Proxy.HTTPSProxy != tt.want {
+				t.Errorf("got proxies %q and %q, want %q", got.Proxy.HTTPProxy, got.Proxy.HTTPSProxy, tt.want)
+			}
+			if mc.Proxy.HTTPProxy != tt.proxy {
+				t.Errorf("the machine config was modified, got proxy %q", mc.Proxy.HTTPProxy)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/system"
+
+	"github.com/shirou/gopsutil/v4/process"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// maxSocketPathLen is the size of sockaddr_un.sun_path on darwin, including the trailing NUL
+	maxSocketPathLen = 104
+	// minFreeBytes is needed to extract the boot image and write the logs
+	minFreeBytes = 1 << 30
+)
+
+func init() {
+	Register("binaries", checkBinaries)
+	Register("ssh-keygen", checkSSHKeygen)
+	Register("workspace", checkWorkspace)
+	Register("disk-space", checkDiskSpace)
+	Register("socket-paths", checkSocketPaths)
+	Register("pid-files", checkPidFiles)
+}
+
+// existingDir returns p or its nearest ancestor which exists, the machine dirs do not exist before the first init
+func existingDir(p string) string {
+	for {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return p
+		}
+		p = parent
+	}
+}
+
+func checkBinaries(_ context.Context, mc *vmconfig.MachineConfig) Result {
+	getters := []func() (string, error){mc.GetGVProxyBin}
+	switch mc.VMType {
+	case vmconfig.KrunKit:
+		getters = append(getters, mc.GetKrunkitBin)
+	case vmconfig.VFkit:
+		getters = append(getters, mc.GetVfkitBin)
+	default:
+		return fail("use --vmm krunkit or --vmm vfkit", "unknown vmm %q", mc.VMType)
+	}
+
+	var found []string
+	for _, get := range getters {
+		bin, err := get()
+		if err != nil {
+			return fail("run ovm from its installation directory", "locate binary failed: %v", err)
+		}
+		info, err := os.Stat(bin)
+		if err != nil {
+			return fail("reinstall ovm, the binary is shipped in its libexec directory", "%s is missing: %v", bin, err)
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			return fail(fmt.Sprintf("run chmod +x %s or reinstall ovm", bin), "%s is not an executable file", bin)
+		}
+		found = append(found, bin)
+	}
+	return pass("found %s", strings.Join(found, ", "))
+}
+
+// checkSSHKeygen is only needed by CreateSSHKey, which runs when the key pair does not exist yet
+func checkSSHKeygen(_ context.Context, mc *vmconfig.MachineConfig) Result {
+	if _, err := os.Stat(mc.SSH.PrivateKeyPath); err == nil {
+		return pass("ssh key %s exists", mc.SSH.PrivateKeyPath)
+	}
+
+	bin, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		return fail("install OpenSSH or add ssh-keygen to PATH", "ssh-keygen is needed to create the ssh key: %v", err)
+	}
+	return pass("found %s", bin)
+}
+
+func checkWorkspace(_ context.Context, mc *vmconfig.MachineConfig) Result {
+	dir := existingDir(filepath.Dir(mc.Dirs.DataDir))
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fail("create the workspace directory", "stat %s failed: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return fail("remove the file or use another --workspace", "%s is not a directory", dir)
+	}
+
+	f, err := os.CreateTemp(dir, ".ovm-doctor-*")
+	if err != nil {
+		return fail(fmt.Sprintf("fix the permission of %s or use another --workspace", dir), "%s is not writable: %v", dir, err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return pass("%s is writable", dir)
+}
+
+// checkDiskSpace compares the free space with the part of the sparse data disk which is not allocated yet
+func checkDiskSpace(_ context.Context, mc *vmconfig.MachineConfig) Result {
+	dir := existingDir(mc.Dirs.DataDir)
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return warn("check the disk of the workspace", "statfs %s failed: %v", dir, err)
+	}
+	free := st.Bavail * uint64(st.Bsize)
+
+	size := mc.Resources.DataDiskSizeGB
+	if size <= 0 {
+		size = define.DataDiskSizeInGB
+	}
+	need := uint64(size) << 30 //nolint:mnd
+	if info, err := os.Stat(mc.DataDisk.Path); err == nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			allocated := uint64(stat.Blocks) * 512 //nolint:mnd
+			need -= min(need, allocated)
+		}
+	}
+
+	if free < minFreeBytes {
+		return fail("free up disk space or use another --workspace", "only %d MiB free on %s", free>>20, dir) //nolint:mnd
+	}
+	if free < need {
+		return warn("free up disk space, the guest fails to write when the disk is full",
+			"%d GiB free on %s, the data disk may grow by %d GiB", free>>30, dir, need>>30) //nolint:mnd
+	}
+	return pass("%d GiB free on %s", free>>30, dir) //nolint:mnd
+}
+
+func checkSocketPaths(_ context.Context, mc *vmconfig.MachineConfig) Result {
+	socks := []string{
+		mc.PodmanSocks.InHost,
+		mc.DockerSocks,
+		mc.RestAPISocks,
+		mc.GetNetworkStackEndpoint(),
+		mc.GetGvproxyServicesEndpoint(),
+		mc.SSHAuthSocks.LocalSocks,
+	}
+	for _, s := range socks {
+		if len(s) >= maxSocketPathLen {
+			return fail("use a shorter --workspace or --name",
+				"socket path %s is %d bytes, the limit is %d", s, len(s), maxSocketPathLen-1)
+		}
+	}
+	return pass("socket paths are shorter than %d bytes", maxSocketPathLen)
+}
+
+// checkPidFiles reports the pid files left by a previous run, start stops a process which still runs with the
+// expected name and fails on a pid file which can not be parsed
+func checkPidFiles(ctx context.Context, mc *vmconfig.MachineConfig) Result {
+	pidFiles := []struct{ path, name string }{
+		{mc.PIDFiles.GvproxyPidFile, define.GvProxyBinaryName},
+		{mc.PIDFiles.KrunKitPidFile, define.KrunkitBinaryName},
+		{mc.PIDFiles.VFKitPidFile, define.VfkitBinaryName},
+	}
+
+	var stale []string
+	for _, f := range pidFiles {
+		if f.path == "" {
+			continue
+		}
+		proc, err := system.FindProcessByPidFile(f.path)
+		if errors.Is(err, process.ErrorProcessNotRunning) {
+			if _, err := os.Stat(f.path); err == nil {
+				stale = append(stale, fmt.Sprintf("%s (not running)", f.path))
+			}
+			continue
+		}
+		if err != nil {
+			return fail(fmt.Sprintf("remove %s", f.path), "read pid file failed: %v", err)
+		}
+
+		name, err := proc.NameWithContext(ctx)
+		if err != nil {
+			stale = append(stale, fmt.Sprintf("%s (pid %d gone)", f.path, proc.Pid))
+			continue
+		}
+		if name == f.name {
+			stale = append(stale, fmt.Sprintf("%s (%s still running as pid %d)", f.path, name, proc.Pid))
+		} else {
+			stale = append(stale, fmt.Sprintf("%s (pid %d reused by %s)", f.path, proc.Pid, name))
+		}
+	}
+
+	if len(stale) > 0 {
+		return warn("stop the other ovm process of this machine, start stops the leftover processes it owns",
+			"pid files of a previous run: %s", strings.Join(stale, ", "))
+	}
+	return pass("no pid files of a previous run")
+}
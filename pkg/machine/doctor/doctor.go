@@ -0,0 +1,103 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package doctor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"bauklotze/pkg/machine/events"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Status string
+
+const (
+	Pass Status = "pass"
+	// Warn does not stop init or start, but is likely to cause a problem later
+	Warn Status = "warn"
+	// Fail stops init and start, the machine can not work until it is fixed
+	Fail Status = "fail"
+)
+
+// Result is the outcome of a check, Remediation tells the user how to fix a warn or fail
+type Result struct {
+	Check       string `json:"check"`
+	Status      Status `json:"status"`
+	Message     string `json:"message"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// Check inspects the host for one requirement of the machine. mc may not be written to disk yet, the checks only
+// use its paths and settings
+type Check struct {
+	Name string
+	Run  func(ctx context.Context, mc *vmconfig.MachineConfig) Result
+}
+
+var checks []Check
+
+// Register adds a check run by Run, the checks run in the order they are registered
+func Register(name string, run func(ctx context.Context, mc *vmconfig.MachineConfig) Result) {
+	checks = append(checks, Check{Name: name, Run: run})
+}
+
+// Run runs all the registered checks, every result is logged and reported as a preflight event
+func Run(ctx context.Context, mc *vmconfig.MachineConfig) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		if ctx.Err() != nil {
+			break
+		}
+
+		r := c.Run(ctx, mc)
+		r.Check = c.Name
+		results = append(results, r)
+
+		switch r.Status {
+		case Pass:
+			logrus.Infof("preflight %s: %s", r.Check, r.Message)
+		default:
+			logrus.Warnf("preflight %s %s: %s, %s", r.Check, r.Status, r.Message, r.Remediation)
+		}
+
+		b, err := json.Marshal(r)
+		if err != nil {
+			logrus.Warnf("marshal preflight result failed: %v", err)
+			continue
+		}
+		events.NotifyPreflight(string(b))
+	}
+	return results
+}
+
+// Failed returns an error listing the failed checks, or nil if none failed
+func Failed(results []Result) error {
+	var errs []error
+	for _, r := range results {
+		if r.Status == Fail {
+			errs = append(errs, fmt.Errorf("%s: %s, %s", r.Check, r.Message, r.Remediation))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("preflight checks failed: %w", errors.Join(errs...))
+}
+
+func pass(format string, a ...any) Result {
+	return Result{Status: Pass, Message: fmt.Sprintf(format, a...)}
+}
+
+func warn(remediation, format string, a ...any) Result {
+	return Result{Status: Warn, Message: fmt.Sprintf(format, a...), Remediation: remediation}
+}
+
+func fail(remediation, format string, a ...any) Result {
+	return Result{Status: Fail, Message: fmt.Sprintf(format, a...), Remediation: remediation}
+}
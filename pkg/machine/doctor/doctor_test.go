@@ -0,0 +1,126 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package doctor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bauklotze/pkg/machine/vmconfig"
+)
+
+// socketConfig returns a machine config with all the sockets in socksDir
+func socketConfig(socksDir string) *vmconfig.MachineConfig {
+	mc := &vmconfig.MachineConfig{}
+	mc.Dirs.SocksDir = socksDir
+	mc.PodmanSocks.InHost = filepath.Join(socksDir, "podman-api.sock")
+	mc.DockerSocks = filepath.Join(socksDir, "docker.sock")
+	mc.RestAPISocks = filepath.Join(socksDir, "ovm_restapi.socks")
+	mc.SSHAuthSocks.LocalSocks = filepath.Join(socksDir, "ssh-auth.sock")
+	return mc
+}
+
+func TestCheckSocketPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		socksDir string
+		want     Status
+	}{
+		{"short workspace", "/tmp/ovm/default/socks", Pass},
+		{"long workspace", "/Users/someone/" + strings.Repeat("w", maxSocketPathLen) + "/default/socks", Fail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := checkSocketPaths(context.Background(), socketConfig(tt.socksDir))
+			if r.Status != tt.want {
+				t.Errorf("got %s (%s), want %s", r.Status, r.Message, tt.want)
+			}
+		})
+	}
+}
+
+// exitedPid returns the pid of a process which has exited
+func exitedPid(t *testing.T) int {
+	t.Helper()
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("run true failed: %v", err)
+	}
+	return cmd.ProcessState.Pid()
+}
+
+func TestCheckPidFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		// content of the gvproxy pid file, no file is written if empty
+		content string
+		want    Status
+		message string
+	}{
+		{name: "no pid files", want: Pass},
+		{name: "stale pid", content: strconv.Itoa(exitedPid(t)), want: Warn, message: "not running"},
+		{name: "pid reused", content: strconv.Itoa(os.Getpid()), want: Warn, message: "reused by"},
+		{name: "invalid pid", content: "not a pid", want: Fail, message: "read pid file failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			mc := &vmconfig.MachineConfig{}
+			mc.PIDFiles.GvproxyPidFile = filepath.Join(dir, "gvproxy.pid")
+			mc.PIDFiles.KrunKitPidFile = filepath.Join(dir, "krunkit.pid")
+			mc.PIDFiles.VFKitPidFile = filepath.Join(dir, "vfkit.pid")
+			if tt.content != "" {
+				if err := os.WriteFile(mc.PIDFiles.GvproxyPidFile, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := checkPidFiles(context.Background(), mc)
+			if r.Status != tt.want {
+				t.Fatalf("got %s (%s), want %s", r.Status, r.Message, tt.want)
+			}
+			if !strings.Contains(r.Message, tt.message) {
+				t.Errorf("got message %q, want it to contain %q", r.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestFailed(t *testing.T) {
+	if err := Failed([]Result{
+		{Check: "binaries", Status: Pass},
+		{Check: "disk-space", Status: Warn, Message: "low", Remediation: "free space"},
+	}); err != nil {
+		t.Fatalf("got %v without failed checks, want nil", err)
+	}
+
+	err := Failed([]Result{
+		{Check: "binaries", Status: Pass, Message: "found"},
+		{Check: "workspace", Status: Fail, Message: "not writable", Remediation: "fix permissions"},
+		{Check: "disk-space", Status: Warn, Message: "low", Remediation: "free space"},
+		{Check: "socket-paths", Status: Fail, Message: "too long", Remediation: "use a shorter --workspace"},
+	})
+	if err == nil {
+		t.Fatal("got nil with failed checks")
+	}
+	msg := err.Error()
+	for _, want := range []string{"workspace: not writable, fix permissions", "socket-paths: too long, use a shorter --workspace"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q does not contain %q", msg, want)
+		}
+	}
+	for _, unwanted := range []string{"binaries", "disk-space"} {
+		if strings.Contains(msg, unwanted) {
+			t.Errorf("error %q lists the check %s which did not fail", msg, unwanted)
+		}
+	}
+}
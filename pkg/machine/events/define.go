@@ -4,13 +4,15 @@
 package events
 
 const (
-	Init string = "init"
-	Run  string = "run"
+	Init   string = "init"
+	Run    string = "run"
+	Doctor string = "doctor"
 )
 
 type InitStageName string
 
 const (
+	InitPreflight    InitStageName = "Preflight"
 	InitNewMachine   InitStageName = "InitNewMachine"
 	ExtractBootImage InitStageName = "ExtractBootImage"
 	InitUpdateConfig InitStageName = "UpdateConfig"
@@ -22,14 +24,19 @@ type RunStageName string
 
 const (
 	LoadMachineConfig RunStageName = "LoadMachineConfig"
+	Preflight         RunStageName = "Preflight"
 	StartGvProxy      RunStageName = "StartGvProxy"
 	StartKrunKit      RunStageName = "StartKrunkit"
 	StartVFKit        RunStageName = "StartVFKit"
 	SyncMachineDisk   RunStageName = "SyncMachineDisk"
+	ProvisionHook     RunStageName = "ProvisionHook"
 	Ready             RunStageName = "Ready"
 	RunExit           RunStageName = "Exit"
 )
 
 const (
 	kError string = "error"
+	// kPreflight carries the json of a doctor check result
+	kPreflight string = "preflight"
+	kExit      string = "Exit"
 )
@@ -5,6 +5,9 @@ package events
 
 import (
 	"net/url"
+	"slices"
+	"sync"
+	"time"
 
 	"bauklotze/pkg/httpclient"
 
@@ -22,12 +25,46 @@ type event struct {
 	Value string
 }
 
+// historySize is the number of events kept for the support bundle
+const historySize = 256
+
+// Record is an event sent by this process
+type Record struct {
+	Time  time.Time `json:"time"`
+	Stage string    `json:"stage"`
+	Name  string    `json:"name"`
+	Value string    `json:"value,omitempty"`
+}
+
+var (
+	historyMu sync.Mutex
+	history   []Record
+)
+
+// History returns the last events sent by this process, the oldest first
+func History() []Record {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	return slices.Clone(history)
+}
+
+func record(e event) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	if len(history) >= historySize {
+		history = slices.Delete(history, 0, len(history)-historySize+1)
+	}
+	history = append(history, Record{Time: time.Now(), Stage: e.Stage, Name: e.Name, Value: e.Value})
+}
+
 func SetReportURL(url string) {
 	reportURL = url
 }
 
-// notify sends an event to the report URL
+// notify records an event and sends it to the report URL
 func notify(e event) {
+	record(e)
 	if reportURL == "" {
 		return
 	}
@@ -88,11 +125,22 @@ func NotifyExit() {
 		NotifyInit(InitExit)
 	case Run:
 		NotifyRun(RunExit)
+	case Doctor:
+		notify(event{Stage: Doctor, Name: kExit})
 	default:
 		logrus.Warnf("Unknown stage %q", CurrentStage)
 	}
 }
 
+// NotifyPreflight reports a doctor check result in the current stage
+func NotifyPreflight(result string) {
+	notify(event{
+		Stage: CurrentStage,
+		Name:  kPreflight,
+		Value: result,
+	})
+}
+
 // NotifyError Generic Notifier for Error
 func NotifyError(err error) {
 	notify(event{
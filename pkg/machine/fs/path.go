@@ -6,14 +6,11 @@ package fs
 import (
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/sirupsen/logrus"
-
-	"github.com/containers/common/pkg/strongunits"
 )
 
 type PathWrapper struct {
@@ -78,34 +75,6 @@ func (m *PathWrapper) IsExist() bool {
 	return true
 }
 
-// DiscardBytesAtBegin discards the first 5 MB of a file if file bigger n Mib
-func (m *PathWrapper) DiscardBytesAtBegin(n strongunits.MiB) error {
-	fileInfo, err := os.Stat(m.path)
-	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
-	}
-	offset := int64(n.ToBytes())
-	logrus.Infof("fileInfo.Size: %d, expact size: %d", fileInfo.Size(), n.ToBytes())
-	if fileInfo.Size() <= offset {
-		return nil
-	}
-	
-	file, _ := os.OpenFile(m.path, os.O_RDONLY, 0) // open the file in read-only mode
-	defer file.Close()                             //nolint:errcheck
-
-	_, _ = file.Seek(offset, io.SeekStart)
-	tempFile, _ := os.CreateTemp("", "trimmed-ovm-log.txt")
-	defer tempFile.Close() //nolint:errcheck
-
-	_, _ = io.Copy(tempFile, file)
-	_ = file.Close()
-	_ = tempFile.Close()
-
-	_ = os.Rename(tempFile.Name(), m.path)
-
-	return nil
-}
-
 func (m *PathWrapper) MakeBaseDir() error {
 	err := os.MkdirAll(filepath.Dir(m.GetPath()), os.ModePerm)
 	if err != nil {
@@ -11,6 +11,7 @@ import (
 	"syscall"
 	"time"
 
+	"bauklotze/pkg/logrotate"
 	"bauklotze/pkg/machine/define"
 	"bauklotze/pkg/machine/events"
 	"bauklotze/pkg/machine/fs"
@@ -39,12 +40,8 @@ func Start(ctx context.Context, mc *vmconfig.MachineConfig) error {
 		return fmt.Errorf("unable to get available ssh port: %w", err)
 	}
 
-	// gvproxy listen a local socks file as Podman API socks (PodmanSocks.InHost)
-	// and forward to the guest's Podman API socks(PodmanSocks.InGuest).
-	gvpCmd.AddForwardSock(mc.PodmanSocks.InHost)
-	gvpCmd.AddForwardDest(mc.PodmanSocks.InGuest)
-	gvpCmd.AddForwardUser(mc.SSH.RemoteUsername)
-	gvpCmd.AddForwardIdentity(mc.SSH.PrivateKeyPath)
+	// the podman api sockets are not forwarded by gvproxy, its ssh forwarder does not verify
+	// the host key of the guest, see machine.StartPodmanForward
 	gvpCmd.PidFile = mc.PIDFiles.GvproxyPidFile
 	gvpCmd.SSHPort = mc.SSH.Port
 
@@ -56,15 +53,27 @@ func Start(ctx context.Context, mc *vmconfig.MachineConfig) error {
 	}
 	gvpCmd.AddVfkitSocket(fmt.Sprintf("unixgram://%s", gvpEndPoint.GetPath()))
 
-	if os.Getenv("OVM_GVPROXY_DEBUG") == "true" {
+	// the services api exposes guest ports on the host at runtime, see ports.go
+	servicesEndPoint := fs.NewFile(mc.GetGvproxyServicesEndpoint())
+	if err := servicesEndPoint.DeleteInDir(vmconfig.Workspace); err != nil {
+		return fmt.Errorf("unable to remove gvproxy services file: %w", err)
+	}
+	gvpCmd.AddServiceEndpoint(fmt.Sprintf("unix://%s", servicesEndPoint.GetPath()))
+
+	if mc.GvproxyDebug || os.Getenv("OVM_GVPROXY_DEBUG") == "true" {
 		logrus.Infof("gvproxy running in debug mode")
 		gvpCmd.Debug = true
 	}
 
 	cmd := exec.CommandContext(ctx, gvpBin, gvpCmd.ToCmdline()...)
 
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// the log file is kept open as long as the start process runs, the output is copied by exec
+	logFile, err := logrotate.New(mc.GetLogPath(define.GvProxyLogName), logrotate.DefaultOptions())
+	if err != nil {
+		return fmt.Errorf("unable to open gvproxy log file: %w", err)
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
@@ -72,6 +81,7 @@ func Start(ctx context.Context, mc *vmconfig.MachineConfig) error {
 	logrus.Infof("gvproxy full cmdline: %q", cmd.Args)
 	events.NotifyRun(events.StartGvProxy)
 	if err := cmd.Start(); err != nil {
+		_ = logFile.Close()
 		return fmt.Errorf("unable to execute: %q: %w", cmd.Args, err)
 	}
 
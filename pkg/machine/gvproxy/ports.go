@@ -0,0 +1,93 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package gvproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bauklotze/pkg/httpclient"
+	"bauklotze/pkg/machine/vmconfig"
+
+	gvproxyClient "github.com/containers/gvisor-tap-vsock/pkg/client"
+	gvproxyTypes "github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/sirupsen/logrus"
+)
+
+const servicesTimeout = 5 * time.Second
+
+// servicesClient talks to the gvproxy services api of the machine
+func servicesClient(mc *vmconfig.MachineConfig) *gvproxyClient.Client {
+	client := &http.Client{
+		Transport: httpclient.CreateUnixTransport(mc.GetGvproxyServicesEndpoint()),
+		Timeout:   servicesTimeout,
+	}
+	// the host is ignored by the unix transport
+	return gvproxyClient.New(client, "http://gvproxy")
+}
+
+// Expose starts listening on the host address of pf and forwards the connections to the guest
+func Expose(mc *vmconfig.MachineConfig, pf vmconfig.PortForward) error {
+	err := servicesClient(mc).Expose(&gvproxyTypes.ExposeRequest{
+		Local:    pf.Local(),
+		Remote:   pf.Remote(),
+		Protocol: gvproxyTypes.TransportProtocol(pf.Protocol),
+	})
+	if err != nil {
+		return fmt.Errorf("expose %s/%s failed: %w", pf.Local(), pf.Protocol, err)
+	}
+	return nil
+}
+
+// Unexpose stops the forward of the host address of pf
+func Unexpose(mc *vmconfig.MachineConfig, pf vmconfig.PortForward) error {
+	err := servicesClient(mc).Unexpose(&gvproxyTypes.UnexposeRequest{
+		Local:    pf.Local(),
+		Protocol: gvproxyTypes.TransportProtocol(pf.Protocol),
+	})
+	if err != nil {
+		return fmt.Errorf("unexpose %s/%s failed: %w", pf.Local(), pf.Protocol, err)
+	}
+	return nil
+}
+
+// ActiveForwards returns the forwards gvproxy is serving, keyed by local address and protocol
+func ActiveForwards(mc *vmconfig.MachineConfig) (map[string]bool, error) {
+	list, err := servicesClient(mc).List()
+	if err != nil {
+		return nil, fmt.Errorf("list gvproxy forwards failed: %w", err)
+	}
+
+	active := make(map[string]bool, len(list))
+	for _, req := range list {
+		active[ForwardKey(req.Local, string(req.Protocol))] = true
+	}
+	return active, nil
+}
+
+func ForwardKey(local, protocol string) string {
+	return local + "/" + protocol
+}
+
+// ApplyPortForwards exposes the forwards saved in the machine config, it is called once gvproxy started.
+// A forward which can not be exposed is skipped, e.g. its host port is taken by another process.
+func ApplyPortForwards(ctx context.Context, mc *vmconfig.MachineConfig) {
+	if len(mc.PortForwards) == 0 {
+		return
+	}
+	if err := waitForSocket(ctx, mc.GetGvproxyServicesEndpoint()); err != nil {
+		logrus.Warnf("gvproxy services not ready, skip port forwards: %v", err)
+		return
+	}
+
+	for _, pf := range mc.PortForwards {
+		if err := Expose(mc, pf); err != nil {
+			logrus.Warnf("re-apply port forward failed: %v", err)
+			continue
+		}
+		logrus.Infof("port forward %s/%s -> %s applied", pf.Local(), pf.Protocol, pf.Remote())
+	}
+}
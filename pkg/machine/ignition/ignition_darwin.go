@@ -6,32 +6,84 @@ package ignition
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 
+	"bauklotze/pkg/machine/define"
 	"bauklotze/pkg/machine/fs"
 	"bauklotze/pkg/machine/vmconfig"
 )
 
-func GenerateScripts(mc *vmconfig.MachineConfig) error {
-	var ignScriptFile = filepath.Join("/", "tmp", "initfs", "ovm_ign.sh")
+// Render generates the ignition script of mc without writing it, the host key must exist
+func Render(mc *vmconfig.MachineConfig) (*DynamicIgnitionV3, error) {
+	return render(mc, false)
+}
+
+// Preview renders the ignition script of mc to debug the provisioning, nothing is created:
+// the host key is rendered as a placeholder until the next start generates it
+func Preview(mc *vmconfig.MachineConfig) (*DynamicIgnitionV3, error) {
+	return render(mc, true)
+}
+
+func render(mc *vmconfig.MachineConfig, preview bool) (*DynamicIgnitionV3, error) {
+	hooks, err := CollectProvisionHooks(mc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect provision hooks: %w", err)
+	}
+
+	hostKeyPath := mc.SSH.HostKeyPath
+	// the key of a config without one is generated at the default path by the next start
+	if preview {
+		hostKeyPath = mc.GetHostKeyPath()
+	}
+
 	ign := NewIgnitionBuilder(
 		&DynamicIgnitionV3{
-			CodeBuffer:      nil,
-			File:            fs.NewFile(ignScriptFile),
-			VMType:          vmconfig.KrunKit,
-			Mounts:          mc.Mounts,
-			SSHIdentityPath: fs.NewFile(mc.SSH.PrivateKeyPath),
+			CodeBuffer:         nil,
+			File:               fs.NewFile(filepath.Join(mc.Dirs.IgnitionDir, define.IgnScriptName)),
+			VMType:             vmconfig.KrunKit,
+			Mounts:             mc.Mounts,
+			SSHIdentityPath:    fs.NewFile(mc.SSH.PrivateKeyPath),
+			SSHHostKeyPath:     hostKeyPath,
+			HostKeyPlaceholder: preview,
+			CACerts:            mc.CACerts,
+			Proxy:              mc.Proxy,
+			Registries:         mc.Registries,
+			ProvisionHooks:     hooks,
+			StatusDir:          filepath.Join(define.IgnGuestDir, define.ProvisionStatusDir),
 		})
 
-	err := ign.GenerateConfig()
-	if err != nil {
-		return fmt.Errorf("failed to generate ignition config: %w", err)
+	if err := ign.GenerateConfig(); err != nil {
+		return nil, fmt.Errorf("failed to generate ignition config: %w", err)
 	}
 
-	err = ign.Write()
+	return ign, nil
+}
+
+// GenerateScripts writes the ignition script of mc and returns the provisioning hooks it runs,
+// they are passed to WatchProvisionHooks so the reported results match the script
+func GenerateScripts(mc *vmconfig.MachineConfig) ([]ProvisionHook, error) {
+	if err := mc.MakeIgnitionDir(); err != nil {
+		return nil, fmt.Errorf("failed to create ignition dir: %w", err)
+	}
+
+	if err := mc.EnsureHostKey(); err != nil {
+		return nil, fmt.Errorf("failed to ensure ssh host key: %w", err)
+	}
+
+	// results of the previous boot must not be reported again
+	if err := os.RemoveAll(provisionStatusDirInHost(mc)); err != nil {
+		return nil, fmt.Errorf("failed to clean provision status dir: %w", err)
+	}
+
+	ign, err := Render(mc)
 	if err != nil {
-		return fmt.Errorf("failed to write ignition file: %w", err)
+		return nil, err
+	}
+
+	if err := ign.Write(); err != nil {
+		return nil, fmt.Errorf("failed to write ignition file: %w", err)
 	}
 
-	return nil
+	return ign.ProvisionHooks, nil
 }
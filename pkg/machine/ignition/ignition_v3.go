@@ -5,25 +5,57 @@ package ignition
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"text/template"
 
+	"bauklotze/pkg/machine/define"
 	"bauklotze/pkg/machine/fs"
+	"bauklotze/pkg/machine/vmconfig"
 	"bauklotze/pkg/machine/volumes"
+	"bauklotze/pkg/shell"
 
 	"github.com/sirupsen/logrus"
 )
 
+// funcMap is shared by all ignition templates, every host supplied value must be passed through quote
+var funcMap = template.FuncMap{
+	"quote": shell.Quote,
+}
+
+// newTemplate parses an ignition script template with funcMap
+func newTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).Funcs(funcMap).Parse(text))
+}
+
 type DynamicIgnitionV3 struct {
 	File            *fs.PathWrapper
 	SSHIdentityPath *fs.PathWrapper
-	VMType          string
-	Mounts          []volumes.Mount
-	TimeZone        string
-	CodeBuffer      *bytes.Buffer
+	// SSHHostKeyPath is the host key of the guest sshd, its fingerprint is verified by every ssh client
+	SSHHostKeyPath string
+	// HostKeyPlaceholder renders a placeholder if the host key does not exist yet, start generates it
+	HostKeyPlaceholder bool
+	VMType             string
+	Mounts             []volumes.Mount
+	TimeZone           string
+	CodeBuffer         *bytes.Buffer
+	CACerts            []string
+	Proxy              vmconfig.ProxyConfig
+	Registries         vmconfig.RegistriesConfig
+	// ProvisionHooks are appended after all the builtin sections
+	ProvisionHooks []ProvisionHook
+	// StatusDir is the guest directory where the hooks report their exit code
+	StatusDir string
+	// Sections is the breakdown of CodeBuffer recorded by GenerateConfig
+	Sections []Section
+	// secrets are removed from the rendered script, see Rendered
+	secrets          []string
+	secretDelimiters []string
 }
 
 func (ign *DynamicIgnitionV3) Write() error {
@@ -32,11 +64,11 @@ func (ign *DynamicIgnitionV3) Write() error {
 		return fmt.Errorf("failed to delete ignition file: %w", err)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(ign.File.GetPath()), os.ModePerm); err != nil {
+	if err := os.MkdirAll(filepath.Dir(ign.File.GetPath()), 0700); err != nil { //nolint:mnd
 		return fmt.Errorf("failed to create directories for ignition file: %w", err)
 	}
 
-	file, err := os.Create(ign.File.GetPath())
+	file, err := os.OpenFile(ign.File.GetPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600) //nolint:mnd
 	if err != nil {
 		return fmt.Errorf("failed to create ignition file: %w", err)
 	}
@@ -51,32 +83,61 @@ func (ign *DynamicIgnitionV3) Write() error {
 
 func (ign *DynamicIgnitionV3) GenerateConfig() error {
 	ign.CodeBuffer = new(bytes.Buffer)
+	ign.Sections = nil
+	ign.secrets = nil
+	ign.secretDelimiters = nil
 
-	err := ign.GenerateMountScripts()
+	err := ign.section("mounts", ign.GenerateMountScripts, func() any { return ign.Mounts })
 	if err != nil {
 		return fmt.Errorf("failed to generate mount scripts: %w", err)
 	}
 
 	if ign.SSHIdentityPath.GetPath() != "" {
-		err = ign.CopySSHIdPub()
+		err = ign.section("ssh-key", ign.CopySSHIdPub, func() any { return ign.SSHIdentityPath.GetPath() + ".pub" })
 		if err != nil {
 			return fmt.Errorf("failed to copy ssh id pub: %w", err)
 		}
 	}
 
-	if err = ign.UpdateTimeZone(); err != nil {
+	if ign.SSHHostKeyPath != "" {
+		err = ign.section("ssh-host-key", ign.CopySSHHostKey, func() any { return ign.SSHHostKeyPath + ".pub" })
+		if err != nil {
+			return fmt.Errorf("failed to copy ssh host key: %w", err)
+		}
+	}
+
+	if err = ign.section("timezone", ign.UpdateTimeZone, func() any { return ign.TimeZone }); err != nil {
 		return fmt.Errorf("failed to update timezone: %w", err)
 	}
 
-	if err = ign.GeneratePodmanMachineConfig(); err != nil {
+	if err = ign.section("podman-machine", ign.GeneratePodmanMachineConfig, func() any { return ign.VMType }); err != nil {
 		return fmt.Errorf("failed to generate podman machine config: %w", err)
 	}
 
+	if err = ign.section("ca-certs", ign.GenerateCACertScripts, func() any { return ign.CACerts }); err != nil {
+		return fmt.Errorf("failed to generate CA certificates scripts: %w", err)
+	}
+
+	err = ign.section("proxy", ign.GenerateProxyScripts, func() any {
+		return resolveProxy(ign.Proxy, os.Getenv, define.HostIPInGuest).redacted()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate proxy scripts: %w", err)
+	}
+
+	if err = ign.section("registries", ign.GenerateRegistriesScripts, func() any { return ign.Registries }); err != nil {
+		return fmt.Errorf("failed to generate registries scripts: %w", err)
+	}
+
+	if err = ign.section("provision", ign.GenerateProvisionScripts, func() any { return ign.ProvisionHooks }); err != nil {
+		return fmt.Errorf("failed to generate provision scripts: %w", err)
+	}
+
 	return nil
 }
 
 func (ign *DynamicIgnitionV3) GeneratePodmanMachineConfig() error {
-	t := template.Must(template.New("PodmanMachineConfigScriptCodes").Parse(podmanMachineConfigScript))
+	t := newTemplate("PodmanMachineConfigScriptCodes", podmanMachineConfigScript)
 	mybuff := new(bytes.Buffer)
 	data := struct {
 		CurrentVMType string
@@ -93,10 +154,10 @@ func (ign *DynamicIgnitionV3) GeneratePodmanMachineConfig() error {
 
 // GenerateMountScripts a template for the virtiofs mount script
 func (ign *DynamicIgnitionV3) GenerateMountScripts() error {
-	t := template.Must(template.New("VirtioFsMountScriptCodes").Parse(VirtioFSMountScript))
+	t := newTemplate("VirtioFsMountScriptCodes", VirtioFSMountScript)
 	mybuff := new(bytes.Buffer)
 	for _, vol := range ign.Mounts {
-		if vol.Type == volumes.VirtIOFS.String() && !strings.HasPrefix(vol.Target, filepath.Dir(ign.File.GetPath())) {
+		if vol.Type == volumes.VirtIOFS.String() && !strings.HasPrefix(vol.Target, define.IgnGuestDir+"/") {
 			data := struct {
 				FsType string
 				Source string
@@ -131,7 +192,7 @@ func (ign *DynamicIgnitionV3) CopySSHIdPub() error {
 	}
 
 	mybuff := new(bytes.Buffer)
-	t := template.Must(template.New("WriteSSHPubKeyScriptCodes").Parse(WriteSSHPubKeyScript))
+	t := newTemplate("WriteSSHPubKeyScriptCodes", WriteSSHPubKeyScript)
 	if err := t.Execute(mybuff, data); err != nil {
 		return fmt.Errorf("failed to execute template: %w", err)
 	}
@@ -140,19 +201,51 @@ func (ign *DynamicIgnitionV3) CopySSHIdPub() error {
 	return nil
 }
 
-func (ign *DynamicIgnitionV3) UpdateTimeZone() error {
-	tz, err := getLocalTimeZone()
+const (
+	sshHostKeyGuest = "/etc/ssh/ssh_host_ed25519_key"
+	// hostKeyPlaceholder stands for the host key which is generated by the next start
+	hostKeyPlaceholder = "<generated on start>"
+)
+
+// CopySSHHostKey installs the host key generated in host, sshd loads it for every new connection
+func (ign *DynamicIgnitionV3) CopySSHHostKey() error {
+	key, err := os.ReadFile(ign.SSHHostKeyPath)
+	if errors.Is(err, os.ErrNotExist) && ign.HostKeyPlaceholder {
+		// the key is redacted from the rendered script, it differs from the public one which must stay visible
+		key = []byte("private key " + hostKeyPlaceholder + "\n")
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read ssh host key: %w", err)
+	}
+	pub, err := os.ReadFile(ign.SSHHostKeyPath + ".pub")
+	if errors.Is(err, os.ErrNotExist) && ign.HostKeyPlaceholder {
+		pub = []byte("ssh-ed25519 " + hostKeyPlaceholder + "\n")
+		err = nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get local timezone: %w", err)
+		return fmt.Errorf("failed to read ssh host public key: %w", err)
+	}
+
+	if err := ign.writeSecretFile(sshHostKeyGuest, key, 0600); err != nil { //nolint:mnd
+		return err
 	}
+	return ign.writeFile(sshHostKeyGuest+".pub", pub, 0644) //nolint:mnd
+}
+
+func (ign *DynamicIgnitionV3) UpdateTimeZone() error {
+	if ign.TimeZone == "" {
+		ign.TimeZone = getLocalTimeZone()
+	}
+
 	data := struct {
 		TimeZone string
 	}{
-		TimeZone: tz,
+		TimeZone: ign.TimeZone,
 	}
 
 	mybuff := new(bytes.Buffer)
-	t := template.Must(template.New("UpdateTimeZoneScriptCodes").Parse(UpdateTimeZoneScript))
+	t := newTemplate("UpdateTimeZoneScriptCodes", UpdateTimeZoneScript)
 	if err := t.Execute(mybuff, data); err != nil {
 		return fmt.Errorf("failed to execute template: %w", err)
 	}
@@ -161,14 +254,65 @@ func (ign *DynamicIgnitionV3) UpdateTimeZone() error {
 	return nil
 }
 
-func NewIgnitionBuilder(dynamicIgnition *DynamicIgnitionV3) *DynamicIgnitionV3 {
-	return dynamicIgnition
+// writeFile appends the code which writes content into the guest file path.
+// The content is passed by a quoted here-document, so it is never expanded by the guest shell.
+func (ign *DynamicIgnitionV3) writeFile(path string, content []byte, perm os.FileMode) error {
+	delimiter := fileDelimiter(content)
+	if bytes.Contains(content, []byte(delimiter)) {
+		return fmt.Errorf("content of %q contains the reserved word %q", path, delimiter)
+	}
+
+	body := string(content)
+	if !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+
+	data := struct {
+		Dir       string
+		Path      string
+		Mode      string
+		Delimiter string
+		Content   string
+	}{
+		Dir:       filepath.Dir(path),
+		Path:      path,
+		Mode:      fmt.Sprintf("%04o", perm.Perm()),
+		Delimiter: delimiter,
+		Content:   body,
+	}
+
+	mybuff := new(bytes.Buffer)
+	t := newTemplate("WriteFileScriptCodes", writeFileScript)
+	if err := t.Execute(mybuff, data); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	ign.CodeBuffer.Write(mybuff.Bytes())
+	return nil
 }
 
-func getLocalTimeZone() (string, error) {
-	tzPath, err := os.Readlink("/etc/localtime")
-	if err != nil {
-		return "", fmt.Errorf("failed to read link: %w", err)
+// fileDelimiter is derived from the digest of the content, so it can not appear in the content itself
+func fileDelimiter(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "OVM_EOF_" + hex.EncodeToString(sum[:8])
+}
+
+// writeSecretFile is writeFile for the content holding credentials, the content is redacted by Rendered,
+// and so is the delimiter because it is a digest of the content
+func (ign *DynamicIgnitionV3) writeSecretFile(path string, content []byte, perm os.FileMode) error {
+	// the trailing newline is kept, it terminates the here-document of the redacted script
+	if secret := strings.TrimSuffix(string(content), "\n"); secret != "" {
+		ign.secrets = append(ign.secrets, secret)
 	}
-	return strings.TrimPrefix(tzPath, "/var/db/timezone/zoneinfo/"), nil
+	ign.secretDelimiters = append(ign.secretDelimiters, fileDelimiter(content))
+	return ign.writeFile(path, content, perm)
+}
+
+// removeFiles appends the code which removes the guest files, used when a section is disabled
+func (ign *DynamicIgnitionV3) removeFiles(paths ...string) {
+	ign.CodeBuffer.WriteString(fmt.Sprintf("\nrm -f %s\n", shell.Join(paths)))
+}
+
+func NewIgnitionBuilder(dynamicIgnition *DynamicIgnitionV3) *DynamicIgnitionV3 {
+	return dynamicIgnition
 }
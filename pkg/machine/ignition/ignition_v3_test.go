@@ -0,0 +1,167 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ignition
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bauklotze/pkg/machine/volumes"
+)
+
+// hostileValues returns values which run touch sentinel if they are not quoted correctly
+func hostileValues(sentinel string) []string {
+	return []string{
+		"$(touch " + sentinel + ")",
+		"`touch " + sentinel + "`",
+		`"; touch ` + sentinel + `; "`,
+		`'; touch ` + sentinel + `; '`,
+		"a\ntouch " + sentinel + "\n",
+		"-rf; touch " + sentinel,
+		"$HOME/it's ${PATH} \\ *",
+	}
+}
+
+// guestStubs replaces the commands changing the guest, mount and ln record their arguments into $MOUNT_ARGS and $LN_ARGS
+const guestStubs = `
+mkdir() { :; }
+ln() { printf '%s\n' "$2" >> "$LN_ARGS"; }
+mount() { printf '%s\n%s\n%s\n' "$2" "$3" "$4" >> "$MOUNT_ARGS"; }
+`
+
+func runScript(t *testing.T, script string, env ...string) {
+	t.Helper()
+
+	check, err := CheckSyntax(context.Background(), script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.OK {
+		t.Fatalf("sh -n failed: %s\n%s", check.Output, script)
+	}
+
+	cmd := exec.Command("sh", "-c", guestStubs+script)
+	cmd.Env = append(os.Environ(), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("script failed: %v\n%s", err, out)
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestGenerateMountScriptsHostile(t *testing.T) {
+	dir := t.TempDir()
+	sentinel := filepath.Join(dir, "pwned")
+	args := filepath.Join(dir, "mount.args")
+
+	for _, v := range hostileValues(sentinel) {
+		// the values with newline can not be compared line by line
+		if strings.Contains(v, "\n") {
+			continue
+		}
+		ign := &DynamicIgnitionV3{
+			CodeBuffer: new(bytes.Buffer),
+			Mounts: []volumes.Mount{{
+				Source: v,
+				Tag:    v,
+				Target: "/mnt/" + v,
+				Type:   volumes.VirtIOFS.String(),
+			}},
+		}
+		if err := ign.GenerateMountScripts(); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = os.Remove(args)
+		runScript(t, ign.CodeBuffer.String(), "MOUNT_ARGS="+args)
+
+		if _, err := os.Stat(sentinel); err == nil {
+			t.Fatalf("sentinel executed by mount of %q", v)
+		}
+		want := []string{volumes.VirtIOFS.String(), v, "/mnt/" + v}
+		got := readLines(t, args)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("mount of %q got args %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestGenerateMountScriptsMultiline(t *testing.T) {
+	dir := t.TempDir()
+	sentinel := filepath.Join(dir, "pwned")
+
+	var mounts []volumes.Mount
+	for _, v := range hostileValues(sentinel) {
+		mounts = append(mounts, volumes.Mount{
+			Source: v,
+			Tag:    v,
+			Target: "/mnt/" + v,
+			Type:   volumes.VirtIOFS.String(),
+		})
+	}
+	ign := &DynamicIgnitionV3{CodeBuffer: new(bytes.Buffer), Mounts: mounts}
+	if err := ign.GenerateMountScripts(); err != nil {
+		t.Fatal(err)
+	}
+
+	runScript(t, ign.CodeBuffer.String(), "MOUNT_ARGS="+filepath.Join(dir, "mount.args"))
+	if _, err := os.Stat(sentinel); err == nil {
+		t.Fatal("sentinel executed by mount scripts")
+	}
+}
+
+func TestUpdateTimeZoneHostile(t *testing.T) {
+	dir := t.TempDir()
+	sentinel := filepath.Join(dir, "pwned")
+	args := filepath.Join(dir, "ln.args")
+
+	for _, v := range hostileValues(sentinel) {
+		if strings.Contains(v, "\n") {
+			continue
+		}
+		ign := &DynamicIgnitionV3{CodeBuffer: new(bytes.Buffer), TimeZone: v}
+		if err := ign.UpdateTimeZone(); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = os.Remove(args)
+		runScript(t, ign.CodeBuffer.String(), "LN_ARGS="+args)
+
+		if _, err := os.Stat(sentinel); err == nil {
+			t.Fatalf("sentinel executed by timezone %q", v)
+		}
+		if got := readLines(t, args); len(got) != 1 || got[0] != "/usr/share/zoneinfo/"+v {
+			t.Errorf("timezone %q got link %q", v, got)
+		}
+	}
+}
+
+func TestGenerateCACertScriptsEmpty(t *testing.T) {
+	ign := &DynamicIgnitionV3{CodeBuffer: new(bytes.Buffer)}
+	if err := ign.GenerateCACertScripts(); err != nil {
+		t.Fatal(err)
+	}
+
+	script := ign.CodeBuffer.String()
+	for _, want := range []string{"rm -f /usr/local/share/ca-certificates/ovm-*.crt", "update-ca-trust"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script without CA bundles does not contain %q:\n%s", want, script)
+		}
+	}
+	if check, err := CheckSyntax(context.Background(), script); err != nil || !check.OK {
+		t.Fatalf("sh -n failed: %v %+v", err, check)
+	}
+}
@@ -0,0 +1,200 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ignition
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/events"
+	"bauklotze/pkg/machine/vmconfig"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ProvisionHook is a user supplied script appended to the ignition script
+type ProvisionHook struct {
+	// ID is unique in one ignition script and names the status file reported by the guest
+	ID      string `json:"id"`
+	Path    string `json:"path"`
+	Once    bool   `json:"once"`
+	Digest  string `json:"digest"`
+	Content []byte `json:"-"`
+}
+
+// onceMarker marks a script in provision.d as run-once, e.g. 10-ca-certs.once.sh
+const onceMarker = ".once."
+
+// CollectProvisionHooks returns the hooks which must run in the next boot, in order:
+// first the --provision-script files in command line order, then the provision.d files sorted by name.
+// Run-once hooks which already succeeded are skipped.
+func CollectProvisionHooks(mc *vmconfig.MachineConfig) ([]ProvisionHook, error) {
+	scripts := append([]vmconfig.ProvisionScript{}, mc.Provision.Scripts...)
+
+	entries, err := os.ReadDir(mc.ProvisionDir())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read provision dir: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		scripts = append(scripts, vmconfig.ProvisionScript{
+			Path: filepath.Join(mc.ProvisionDir(), name),
+			Once: strings.Contains(name, onceMarker),
+		})
+	}
+
+	hooks := make([]ProvisionHook, 0, len(scripts))
+	for i, script := range scripts {
+		content, err := os.ReadFile(script.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read provision script %q: %w", script.Path, err)
+		}
+
+		sum := sha256.Sum256(content)
+		digest := hex.EncodeToString(sum[:])
+		if script.Once && mc.IsProvisionDone(digest) {
+			logrus.Infof("Provision hook %q already succeeded, skip it", script.Path)
+			continue
+		}
+
+		hooks = append(hooks, ProvisionHook{
+			ID:      fmt.Sprintf("%02d-%s", i, filepath.Base(script.Path)),
+			Path:    script.Path,
+			Once:    script.Once,
+			Digest:  digest,
+			Content: content,
+		})
+	}
+
+	return hooks, nil
+}
+
+// GenerateProvisionScripts appends the provisioning hooks, each hook writes its exit code into the status dir
+func (ign *DynamicIgnitionV3) GenerateProvisionScripts() error {
+	t := newTemplate("ProvisionHookScriptCodes", provisionHookScript)
+	for _, hook := range ign.ProvisionHooks {
+		script := filepath.Join(provisionGuestDir, hook.ID)
+		if err := ign.writeFile(script, hook.Content, 0700); err != nil { //nolint:mnd
+			return fmt.Errorf("failed to write provision script %q: %w", hook.Path, err)
+		}
+
+		data := struct {
+			ID        string
+			Script    string
+			StatusDir string
+			Status    string
+			Shebang   bool
+		}{
+			ID:        hook.ID,
+			Script:    script,
+			StatusDir: ign.StatusDir,
+			Status:    filepath.Join(ign.StatusDir, hook.ID),
+			Shebang:   bytes.HasPrefix(hook.Content, []byte("#!")),
+		}
+
+		mybuff := new(bytes.Buffer)
+		if err := t.Execute(mybuff, data); err != nil {
+			return fmt.Errorf("failed to execute template: %w", err)
+		}
+		ign.CodeBuffer.Write(mybuff.Bytes())
+	}
+	return nil
+}
+
+const (
+	provisionGuestDir    = "/tmp/ovm-provision"
+	provisionPollTimeout = 30 * time.Minute
+	provisionPollBackoff = 500 * time.Millisecond
+)
+
+// WatchProvisionHooks waits for the guest to report the result of every hook, and sends each result
+// as an event. Run-once hooks which succeeded are recorded in the machine config. hooks are those
+// returned by GenerateScripts, provision.d may have changed since the script was written.
+func WatchProvisionHooks(ctx context.Context, mc *vmconfig.MachineConfig, hooks []ProvisionHook) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	statusDir := provisionStatusDirInHost(mc)
+	pending := make(map[string]ProvisionHook, len(hooks))
+	for _, hook := range hooks {
+		pending[hook.ID] = hook
+	}
+
+	timeout := time.After(provisionPollTimeout)
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cancel WatchProvisionHooks, ctx has been done: %w", context.Cause(ctx))
+		case <-timeout:
+			return fmt.Errorf("timeout reached while waiting for %d provision hooks", len(pending))
+		case <-time.After(provisionPollBackoff):
+		}
+
+		for id, hook := range pending {
+			b, err := os.ReadFile(filepath.Join(statusDir, id))
+			if err != nil {
+				continue
+			}
+
+			// the status file is created before the exit code is written
+			code, err := strconv.Atoi(strings.TrimSpace(string(b)))
+			if err != nil {
+				continue
+			}
+			delete(pending, id)
+
+			reportProvisionHook(mc, hook, code)
+		}
+	}
+
+	return nil
+}
+
+func reportProvisionHook(mc *vmconfig.MachineConfig, hook ProvisionHook, code int) {
+	if code != 0 {
+		logrus.Warnf("Provision hook %q failed with exit code %d", hook.Path, code)
+		events.NotifyRun(events.ProvisionHook, fmt.Sprintf("%s: failed with exit code %d", hook.ID, code))
+		return
+	}
+
+	logrus.Infof("Provision hook %q succeeded", hook.Path)
+	events.NotifyRun(events.ProvisionHook, fmt.Sprintf("%s: success", hook.ID))
+
+	if !hook.Once {
+		return
+	}
+
+	mc.Lock()
+	defer mc.Unlock()
+	mc.Provision.Done = append(mc.Provision.Done, hook.Digest)
+	if err := mc.Write(); err != nil {
+		logrus.Warnf("Failed to record provision hook %q: %v", hook.Path, err)
+	}
+}
+
+// provisionStatusDirInHost is where the guest writes the exit code of each hook
+func provisionStatusDirInHost(mc *vmconfig.MachineConfig) string {
+	return filepath.Join(mc.Dirs.IgnitionDir, define.ProvisionStatusDir)
+}
@@ -0,0 +1,148 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ignition
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"bauklotze/pkg/machine/vmconfig"
+)
+
+const (
+	registriesConfGuest = "/etc/containers/registries.conf.d/99-ovm.conf"
+	registriesAuthGuest = "/root/.config/containers/auth.json"
+)
+
+// GuestFile is a file rendered on the host for the guest, a nil Content means the file must be removed
+type GuestFile struct {
+	Path    string
+	Content []byte
+	Perm    os.FileMode
+	// Secret content holds credentials, it must never be logged or rendered
+	Secret bool
+}
+
+// RegistriesFiles renders the registries configuration into the guest files
+func RegistriesFiles(cfg vmconfig.RegistriesConfig) ([]GuestFile, error) {
+	conf, err := renderRegistriesConf(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render registries.conf: %w", err)
+	}
+
+	auth, err := readAuthFile(cfg.AuthFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry auth file %q: %w", cfg.AuthFile, err)
+	}
+
+	return []GuestFile{
+		{Path: registriesConfGuest, Content: conf, Perm: 0644},               //nolint:mnd
+		{Path: registriesAuthGuest, Content: auth, Perm: 0600, Secret: true}, //nolint:mnd
+	}, nil
+}
+
+// tomlString quotes s as a TOML basic string, a JSON string is a valid one
+func tomlString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func tomlStrings(s []string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// renderRegistriesConf renders the registries.conf v2 drop-in, it returns nil if nothing is configured
+func renderRegistriesConf(cfg vmconfig.RegistriesConfig) ([]byte, error) {
+	if len(cfg.Mirrors) == 0 && len(cfg.Insecure) == 0 && len(cfg.Search) == 0 {
+		return nil, nil
+	}
+
+	out := new(bytes.Buffer)
+	out.WriteString("# Generated by ovm, changes are overwritten on every boot\n")
+
+	if len(cfg.Search) > 0 {
+		fmt.Fprintf(out, "unqualified-search-registries = %s\n", tomlStrings(cfg.Search))
+	}
+
+	insecure := func(location string) bool {
+		return slices.Contains(cfg.Insecure, location)
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range cfg.Mirrors {
+		if seen[m.Registry] {
+			return nil, fmt.Errorf("registry %q has mirrors configured more than once", m.Registry)
+		}
+		seen[m.Registry] = true
+
+		fmt.Fprintf(out, "\n[[registry]]\nprefix = %s\nlocation = %s\n", tomlString(m.Registry), tomlString(m.Registry))
+		if insecure(m.Registry) {
+			out.WriteString("insecure = true\n")
+		}
+		for _, mirror := range m.Mirrors {
+			fmt.Fprintf(out, "\n[[registry.mirror]]\nlocation = %s\n", tomlString(mirror))
+			if insecure(mirror) {
+				out.WriteString("insecure = true\n")
+			}
+		}
+	}
+
+	for _, r := range cfg.Insecure {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		fmt.Fprintf(out, "\n[[registry]]\nlocation = %s\ninsecure = true\n", tomlString(r))
+	}
+
+	return out.Bytes(), nil
+}
+
+// readAuthFile reads and validates a containers auth.json, it returns nil if no file is configured
+func readAuthFile(f string) ([]byte, error) {
+	if f == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var auth struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}
+	if err := json.Unmarshal(b, &auth); err != nil {
+		return nil, fmt.Errorf("invalid auth file: %w", err)
+	}
+
+	return b, nil
+}
+
+// GenerateRegistriesScripts writes the registries configuration, or removes it if nothing is configured
+func (ign *DynamicIgnitionV3) GenerateRegistriesScripts() error {
+	files, err := RegistriesFiles(ign.Registries)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.Content == nil {
+			ign.removeFiles(f.Path)
+			continue
+		}
+		write := ign.writeFile
+		if f.Secret {
+			write = ign.writeSecretFile
+		}
+		if err := write(f.Path, f.Content, f.Perm); err != nil {
+			return fmt.Errorf("failed to write %q: %w", f.Path, err)
+		}
+	}
+	return nil
+}
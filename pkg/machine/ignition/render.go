@@ -0,0 +1,101 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ignition
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"bauklotze/pkg/machine/vmconfig"
+)
+
+// Section is a part of the ignition script with the inputs it is generated from
+type Section struct {
+	Name   string `json:"name"`
+	Inputs any    `json:"inputs"`
+	Script string `json:"script"`
+}
+
+// section runs gen and records the code it appends as a section, inputs is called after gen
+// because some inputs are resolved by the generator, e.g. the timezone
+func (ign *DynamicIgnitionV3) section(name string, gen func() error, inputs func() any) error {
+	start := ign.CodeBuffer.Len()
+	if err := gen(); err != nil {
+		return err
+	}
+
+	ign.Sections = append(ign.Sections, Section{
+		Name:   name,
+		Inputs: inputs(),
+		Script: ign.CodeBuffer.String()[start:],
+	})
+	return nil
+}
+
+// Rendered is the result of a dry-run
+type Rendered struct {
+	Script   string       `json:"script"`
+	Sections []Section    `json:"sections"`
+	Check    *CheckResult `json:"check,omitempty"`
+}
+
+// CheckResult is the result of the syntax check of the script
+type CheckResult struct {
+	OK     bool   `json:"ok"`
+	Output string `json:"output,omitempty"`
+}
+
+const (
+	redacted          = vmconfig.RedactedMarker
+	redactedDelimiter = "OVM_EOF_REDACTED"
+)
+
+// Rendered returns the script and its breakdown, private keys and credentials are redacted
+// so the output can be shared, the redacted script still passes the syntax check
+func (ign *DynamicIgnitionV3) Rendered() *Rendered {
+	redact := func(s string) string {
+		for _, secret := range ign.secrets {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+		for _, delimiter := range ign.secretDelimiters {
+			s = strings.ReplaceAll(s, delimiter, redactedDelimiter)
+		}
+		return s
+	}
+
+	sections := make([]Section, 0, len(ign.Sections))
+	for _, s := range ign.Sections {
+		s.Script = redact(s.Script)
+		sections = append(sections, s)
+	}
+
+	return &Rendered{
+		Script:   redact(ign.CodeBuffer.String()),
+		Sections: sections,
+	}
+}
+
+// CheckSyntax parses the script with `sh -n`, the script is not executed
+func CheckSyntax(ctx context.Context, script string) (*CheckResult, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-n")
+	cmd.Stdin = strings.NewReader(script)
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("failed to run sh -n: %w", err)
+	}
+
+	return &CheckResult{
+		OK:     err == nil,
+		Output: out.String(),
+	}, nil
+}
@@ -3,24 +3,63 @@
 
 package ignition
 
+// All values interpolated into the scripts below come from the host (paths, keys, timezone...),
+// they must go through the `quote` function so that they are never interpreted by the guest shell.
+
 const VirtioFSMountScript = `
-echo "Mounting {{.Source}} Tag {{.Tag}} to {{.Target}}"
-mkdir -p "{{.Target}}"
-mount -t "{{.FsType}}" "{{.Tag}}" "{{.Target}}" || echo "Error: Mounting {{.Source}} to {{.Target}} failed"
+echo {{quote (printf "Mounting %s Tag %s to %s" .Source .Tag .Target)}}
+mkdir -p {{quote .Target}}
+mount -t {{quote .FsType}} {{quote .Tag}} {{quote .Target}} || echo {{quote (printf "Error: Mounting %s to %s failed" .Source .Target)}}
 `
 
 const WriteSSHPubKeyScript = `
 echo "Writing SSH public key to /root/.ssh/authorized_keys"
 mkdir -p "/root/.ssh/"
-echo "{{.Target}}" >> "/root/.ssh/authorized_keys"
+printf '%s\n' {{quote .Target}} >> "/root/.ssh/authorized_keys"
 `
 
 const UpdateTimeZoneScript = `
-echo "Setting timezone to {{.TimeZone}}"
-ln -sf "/usr/share/zoneinfo/{{.TimeZone}}" "/etc/localtime"
+echo {{quote (printf "Setting timezone to %s" .TimeZone)}}
+ln -sf {{quote (printf "/usr/share/zoneinfo/%s" .TimeZone)}} "/etc/localtime"
 `
 
 const podmanMachineConfigScript = `
 echo "Generating podman machine config"
-echo "{{.CurrentVMType}}" > "/etc/containers/podman-machine"
+printf '%s\n' {{quote .CurrentVMType}} > "/etc/containers/podman-machine"
+`
+
+const writeFileScript = `
+echo {{quote (printf "Writing %s" .Path)}}
+mkdir -p {{quote .Dir}}
+cat > {{quote .Path}} <<'{{.Delimiter}}'
+{{.Content}}{{.Delimiter}}
+chmod {{.Mode}} {{quote .Path}}
+`
+
+const provisionHookScript = `
+echo {{quote (printf "Running provision hook %s" .ID)}}
+mkdir -p {{quote .StatusDir}}
+{{if .Shebang}}{{quote .Script}}{{else}}sh {{quote .Script}}{{end}}
+echo "$?" > {{quote .Status}}
+`
+
+// removeCACertsScript drops the bundles installed by the previous boot, the globs must stay unquoted
+const removeCACertsScript = `
+echo "Removing previous CA certificates"
+rm -f /usr/local/share/ca-certificates/ovm-*.crt /etc/pki/ca-trust/source/anchors/ovm-*.crt
+`
+
+const updateCACertsScript = `
+echo "Updating CA certificates"
+if command -v update-ca-certificates >/dev/null 2>&1; then
+  update-ca-certificates || echo "Error: update-ca-certificates failed"
+elif command -v update-ca-trust >/dev/null 2>&1; then
+  mkdir -p "/etc/pki/ca-trust/source/anchors"
+  for f in /usr/local/share/ca-certificates/ovm-*.crt; do
+    if [ -e "$f" ]; then cp "$f" "/etc/pki/ca-trust/source/anchors/"; fi
+  done
+  update-ca-trust || echo "Error: update-ca-trust failed"
+else
+  echo "Error: no tool found to update CA certificates"
+fi
 `
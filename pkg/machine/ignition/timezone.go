@@ -0,0 +1,202 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ignition
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	localtimeFile   = "/etc/localtime"
+	defaultTimeZone = "UTC"
+)
+
+// zoneinfoDirs are the known tz database locations, macOS first
+var zoneinfoDirs = []string{
+	"/var/db/timezone/zoneinfo",
+	"/usr/share/zoneinfo",
+	"/usr/share/lib/zoneinfo",
+	"/usr/lib/zoneinfo",
+	"/etc/zoneinfo",
+}
+
+// zoneinfoSegment matches the tz database directory in a path which is not in zoneinfoDirs,
+// e.g. /usr/share/zoneinfo.default/ on macOS or a nix store path
+var zoneinfoSegment = regexp.MustCompile(`(^|/)zoneinfo[^/]*/`)
+
+// ianaName is the shape of an IANA timezone name, e.g. America/Argentina/Buenos_Aires or Etc/GMT+8
+var ianaName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+\-]*(/[A-Za-z0-9_+\-]+)*$`)
+
+// timeZoneResolver finds the IANA name of the host timezone
+type timeZoneResolver struct {
+	getenv       func(string) string
+	localtime    string
+	zoneinfoDirs []string
+}
+
+// getLocalTimeZone returns the IANA name of the host timezone, it never fails: UTC is used
+// if the timezone can not be resolved, the guest must boot anyway
+func getLocalTimeZone() string {
+	r := &timeZoneResolver{
+		getenv:       os.Getenv,
+		localtime:    localtimeFile,
+		zoneinfoDirs: zoneinfoDirs,
+	}
+	return r.name()
+}
+
+// name returns the resolved timezone, or UTC if it can not be resolved
+func (r *timeZoneResolver) name() string {
+	tz, err := r.resolve()
+	if err != nil {
+		logrus.Warnf("Failed to detect the host timezone, use %s in guest: %v", defaultTimeZone, err)
+		return defaultTimeZone
+	}
+	return tz
+}
+
+func (r *timeZoneResolver) resolve() (string, error) {
+	// TZ is either a name, or a path to a tz file, optionally prefixed by ':'
+	if tz := strings.TrimPrefix(r.getenv("TZ"), ":"); tz != "" {
+		name, err := r.fromTZ(tz)
+		if err == nil {
+			return name, nil
+		}
+		logrus.Warnf("Ignore TZ=%q: %v", tz, err)
+	}
+
+	return r.fromFile(r.localtime)
+}
+
+func (r *timeZoneResolver) fromTZ(tz string) (string, error) {
+	if filepath.IsAbs(tz) {
+		return r.fromFile(tz)
+	}
+	return validateTimeZone(tz)
+}
+
+// fromFile resolves the name of a tz file, which is either a symlink into a tz database or a copy of a tz file
+func (r *timeZoneResolver) fromFile(file string) (string, error) {
+	target, err := os.Readlink(file)
+	if err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(file), target)
+		}
+		if name, ok := r.trimZoneinfo(filepath.Clean(target)); ok {
+			return validateTimeZone(name)
+		}
+		file = target
+	}
+
+	if name, ok := r.trimZoneinfo(file); ok {
+		return validateTimeZone(name)
+	}
+
+	name, err := r.findCopy(file)
+	if err != nil {
+		return "", err
+	}
+	return validateTimeZone(name)
+}
+
+// trimZoneinfo returns the part of path after the tz database directory
+func (r *timeZoneResolver) trimZoneinfo(path string) (string, bool) {
+	for _, dir := range r.zoneinfoDirs {
+		if name, ok := strings.CutPrefix(path, dir+"/"); ok {
+			return trimZoneVariant(name), true
+		}
+	}
+
+	loc := zoneinfoSegment.FindAllStringIndex(path, -1)
+	if len(loc) == 0 {
+		return "", false
+	}
+	return trimZoneVariant(path[loc[len(loc)-1][1]:]), true
+}
+
+// trimZoneVariant strips the posix/ and right/ variants of the tz database
+func trimZoneVariant(name string) string {
+	for _, variant := range []string{"posix/", "right/"} {
+		if n, ok := strings.CutPrefix(name, variant); ok {
+			return n
+		}
+	}
+	return name
+}
+
+var errFound = errors.New("found")
+
+// findCopy looks up a tz file which has the same content as file in the tz databases
+func (r *timeZoneResolver) findCopy(file string) (string, error) {
+	want, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %q: %w", file, err)
+	}
+
+	for _, dir := range r.zoneinfoDirs {
+		var name string
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil //nolint:nilerr
+			}
+			if d.IsDir() {
+				if base := d.Name(); base == "posix" || base == "right" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil || info.Size() != int64(len(want)) {
+				return nil //nolint:nilerr
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil || !bytes.Equal(got, want) {
+				return nil //nolint:nilerr
+			}
+
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return nil //nolint:nilerr
+			}
+			if _, err := validateTimeZone(rel); err != nil {
+				// e.g. localtime or posixrules in the tz database
+				return nil
+			}
+			name = rel
+			return errFound
+		})
+		if errors.Is(err, errFound) {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("%q is not found in the tz databases", file)
+}
+
+// notZones are files of the tz databases which are not timezones, LoadLocation accepts them
+var notZones = []string{"Local", "localtime", "posixrules"}
+
+// validateTimeZone checks name is a known IANA timezone, it is used to build a path in guest
+func validateTimeZone(name string) (string, error) {
+	if slices.Contains(notZones, name) || !ianaName.MatchString(name) {
+		return "", fmt.Errorf("invalid timezone name %q", name)
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return "", fmt.Errorf("unknown timezone %q: %w", name, err)
+	}
+	return name, nil
+}
@@ -0,0 +1,167 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ignition
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// validateTimeZone loads the names, the test must not depend on the tz database of the host
+	_ "time/tzdata"
+)
+
+// zoneinfoTree creates a tz database in root/zoneinfo, the zone files have distinct content
+func zoneinfoTree(t *testing.T, root string) string {
+	t.Helper()
+
+	db := filepath.Join(root, "zoneinfo")
+	files := map[string]string{
+		"Asia/Shanghai":    "TZif Asia/Shanghai",
+		"Europe/Berlin":    "TZif Europe/Berlin",
+		"America/New_York": "TZif America/New_York",
+		"Unknown/Zone":     "TZif Unknown/Zone",
+		// the variant is a copy of the zone file, findCopy must skip it
+		"posix/Europe/Berlin": "TZif Europe/Berlin",
+		"localtime":           "TZif localtime",
+	}
+	for name, content := range files {
+		path := filepath.Join(db, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, path, content)
+	}
+	return db
+}
+
+func TestTimeZoneResolver(t *testing.T) {
+	root := t.TempDir()
+	db := zoneinfoTree(t, root)
+
+	tests := []struct {
+		name string
+		tz   string
+		// setup creates the localtime file
+		setup func(t *testing.T, localtime string)
+		want  string
+	}{
+		{
+			name:  "TZ name takes precedence",
+			tz:    "Europe/Berlin",
+			setup: symlink(filepath.Join(db, "Asia/Shanghai")),
+			want:  "Europe/Berlin",
+		},
+		{
+			name:  "TZ with colon prefix",
+			tz:    ":America/New_York",
+			setup: symlink(filepath.Join(db, "Asia/Shanghai")),
+			want:  "America/New_York",
+		},
+		{
+			name:  "TZ path into the tz database",
+			tz:    filepath.Join(db, "Europe/Berlin"),
+			setup: symlink(filepath.Join(db, "Asia/Shanghai")),
+			want:  "Europe/Berlin",
+		},
+		{
+			name:  "invalid TZ falls back to localtime",
+			tz:    "Not a/zone",
+			setup: symlink(filepath.Join(db, "Asia/Shanghai")),
+			want:  "Asia/Shanghai",
+		},
+		{
+			name:  "absolute symlink",
+			setup: symlink(filepath.Join(db, "Asia/Shanghai")),
+			want:  "Asia/Shanghai",
+		},
+		{
+			name:  "relative symlink",
+			setup: symlink("../zoneinfo/Europe/Berlin"),
+			want:  "Europe/Berlin",
+		},
+		{
+			name:  "symlink into the posix variant",
+			setup: symlink(filepath.Join(db, "posix/Europe/Berlin")),
+			want:  "Europe/Berlin",
+		},
+		{
+			name:  "symlink into an unknown tz database",
+			setup: symlink("/usr/share/zoneinfo.default/America/New_York"),
+			want:  "America/New_York",
+		},
+		{
+			name:  "copied file",
+			setup: copyOf(filepath.Join(db, "Europe/Berlin")),
+			want:  "Europe/Berlin",
+		},
+		{
+			name:  "copied file not in the tz database",
+			setup: func(t *testing.T, localtime string) { writeTestFile(t, localtime, "TZif custom") },
+			want:  defaultTimeZone,
+		},
+		{
+			name:  "invalid IANA name",
+			setup: symlink(filepath.Join(db, "Unknown/Zone")),
+			want:  defaultTimeZone,
+		},
+		{
+			name:  "name which is not a timezone",
+			setup: symlink(filepath.Join(db, "localtime")),
+			want:  defaultTimeZone,
+		},
+		{
+			name:  "no TZ and no localtime",
+			setup: func(*testing.T, string) {},
+			want:  defaultTimeZone,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			etc := filepath.Join(root, fmt.Sprintf("etc%d", i))
+			if err := os.MkdirAll(etc, 0755); err != nil {
+				t.Fatal(err)
+			}
+			// the relative symlink is resolved against the directory of localtime, a sibling of zoneinfo
+			localtime := filepath.Join(etc, "localtime")
+			tt.setup(t, localtime)
+
+			r := &timeZoneResolver{
+				getenv: func(key string) string {
+					if key == "TZ" {
+						return tt.tz
+					}
+					return ""
+				},
+				localtime:    localtime,
+				zoneinfoDirs: []string{db},
+			}
+			if got := r.name(); got != tt.want {
+				t.Errorf("got timezone %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func symlink(target string) func(t *testing.T, localtime string) {
+	return func(t *testing.T, localtime string) {
+		t.Helper()
+		if err := os.Symlink(target, localtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func copyOf(src string) func(t *testing.T, localtime string) {
+	return func(t *testing.T, localtime string) {
+		t.Helper()
+		b, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, localtime, string(b))
+	}
+}
@@ -0,0 +1,244 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ignition
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/shell"
+)
+
+const (
+	caCertsGuestDir      = "/usr/local/share/ca-certificates"
+	proxyProfileGuest    = "/etc/profile.d/ovm-proxy.sh"
+	proxyContainersGuest = "/etc/containers/containers.conf.d/99-ovm-proxy.conf"
+)
+
+// GenerateCACertScripts installs the configured CA bundles into the guest trust store,
+// the bundles installed before are always removed so a dropped bundle is no longer trusted
+func (ign *DynamicIgnitionV3) GenerateCACertScripts() error {
+	ign.CodeBuffer.WriteString(removeCACertsScript)
+
+	for i, f := range ign.CACerts {
+		bundle, err := readCertificates(f)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle %q: %w", f, err)
+		}
+
+		target := filepath.Join(caCertsGuestDir, fmt.Sprintf("ovm-%02d.crt", i))
+		if err := ign.writeFile(target, bundle, define.DefaultFilePerm); err != nil {
+			return fmt.Errorf("failed to write CA bundle %q: %w", f, err)
+		}
+	}
+
+	ign.CodeBuffer.WriteString(updateCACertsScript)
+	return nil
+}
+
+// readCertificates reads a PEM bundle and returns only its certificates, re-encoded.
+// Anything else in the file (private keys, garbage) never reaches the guest.
+func readCertificates(f string) ([]byte, error) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	out := new(bytes.Buffer)
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		if err := pem.Encode(out, &pem.Block{Type: block.Type, Bytes: block.Bytes}); err != nil {
+			return nil, fmt.Errorf("failed to encode certificate: %w", err)
+		}
+	}
+
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("no certificate found in %q", f)
+	}
+	return out.Bytes(), nil
+}
+
+// proxyEnv is the resolved proxy settings written into the guest
+type proxyEnv struct {
+	HTTPProxy  string `json:"httpProxy,omitempty"`
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+	NoProxy    string `json:"noProxy,omitempty"`
+}
+
+func (p proxyEnv) isEmpty() bool {
+	return p.HTTPProxy == "" && p.HTTPSProxy == ""
+}
+
+// hasCredentials reports whether a proxy URL carries a user or password
+func (p proxyEnv) hasCredentials() bool {
+	return proxyUser(p.HTTPProxy) != nil || proxyUser(p.HTTPSProxy) != nil
+}
+
+// redacted returns p with the credentials of the proxy URLs replaced, it is used by the dry-run
+func (p proxyEnv) redacted() proxyEnv {
+	p.HTTPProxy = vmconfig.RedactProxy(p.HTTPProxy)
+	p.HTTPSProxy = vmconfig.RedactProxy(p.HTTPSProxy)
+	return p
+}
+
+func proxyUser(proxy string) *url.Userinfo {
+	if proxy == "" {
+		return nil
+	}
+	u, _ := vmconfig.ParseProxy(proxy)
+	if u == nil {
+		return nil
+	}
+	return u.User
+}
+
+// vars returns the proxy variables in both upper and lower case, as the tools in guest read either of them
+func (p proxyEnv) vars() []string {
+	var vars []string
+	add := func(name, value string) {
+		if value == "" {
+			return
+		}
+		vars = append(vars, name+"="+value, strings.ToLower(name)+"="+value)
+	}
+	add("HTTP_PROXY", p.HTTPProxy)
+	add("HTTPS_PROXY", p.HTTPSProxy)
+	add("NO_PROXY", p.NoProxy)
+	return vars
+}
+
+// guestNoProxy are always bypassing the proxy, they are local to the guest
+var guestNoProxy = []string{"localhost", "127.0.0.1", "::1"}
+
+// resolveProxy merges the explicit settings with the ones found by getenv when FromEnv is set,
+// proxies listening on the host's loopback are rewritten to hostAddr which gvproxy forwards to the host
+func resolveProxy(cfg vmconfig.ProxyConfig, getenv func(string) string, hostAddr string) proxyEnv {
+	lookup := func(explicit, name string) string {
+		if explicit != "" || !cfg.FromEnv {
+			return explicit
+		}
+		if v := getenv(name); v != "" {
+			return v
+		}
+		return getenv(strings.ToLower(name))
+	}
+
+	p := proxyEnv{
+		HTTPProxy:  rewriteLoopback(lookup(cfg.HTTPProxy, "HTTP_PROXY"), hostAddr),
+		HTTPSProxy: rewriteLoopback(lookup(cfg.HTTPSProxy, "HTTPS_PROXY"), hostAddr),
+	}
+	if p.isEmpty() {
+		return p
+	}
+
+	noProxy := strings.Split(lookup(cfg.NoProxy, "NO_PROXY"), ",")
+	for _, h := range guestNoProxy {
+		if !containsHost(noProxy, h) {
+			noProxy = append(noProxy, h)
+		}
+	}
+
+	var hosts []string
+	for _, h := range noProxy {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	p.NoProxy = strings.Join(hosts, ",")
+
+	return p
+}
+
+func containsHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if strings.TrimSpace(h) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteLoopback replaces a loopback host in the proxy URL with hostAddr, the guest's loopback is not the host's
+func rewriteLoopback(proxy, hostAddr string) string {
+	if proxy == "" {
+		return ""
+	}
+
+	u, noScheme := vmconfig.ParseProxy(proxy)
+	if u == nil {
+		return proxy
+	}
+
+	h := u.Hostname()
+	ip := net.ParseIP(h)
+	if h != "localhost" && (ip == nil || !(ip.IsLoopback() || ip.IsUnspecified())) {
+		return proxy
+	}
+
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(hostAddr, port)
+	} else {
+		u.Host = hostAddr
+	}
+
+	if noScheme {
+		return strings.TrimPrefix(u.String(), "http://")
+	}
+	return u.String()
+}
+
+// GenerateProxyScripts writes the proxy environment for the shell and for podman, or removes them if no proxy is used
+func (ign *DynamicIgnitionV3) GenerateProxyScripts() error {
+	p := resolveProxy(ign.Proxy, os.Getenv, define.HostIPInGuest)
+	if p.isEmpty() {
+		ign.removeFiles(proxyProfileGuest, proxyContainersGuest)
+		return nil
+	}
+
+	// the files embed the proxy URLs, with credentials they are not shown by the dry-run at all
+	write := ign.writeFile
+	if p.hasCredentials() {
+		write = ign.writeSecretFile
+	}
+
+	profile := new(bytes.Buffer)
+	for _, v := range p.vars() {
+		fmt.Fprintf(profile, "export %s\n", shell.Quote(v))
+	}
+	if err := write(proxyProfileGuest, profile.Bytes(), define.DefaultFilePerm); err != nil {
+		return fmt.Errorf("failed to write proxy profile: %w", err)
+	}
+
+	// a JSON string array is a valid TOML array
+	env, err := json.Marshal(p.vars())
+	if err != nil {
+		return fmt.Errorf("failed to encode proxy env: %w", err)
+	}
+	containersConf := fmt.Sprintf("[engine]\nenv = %s\n", env)
+	if err := write(proxyContainersGuest, []byte(containersConf), define.DefaultFilePerm); err != nil {
+		return fmt.Errorf("failed to write containers proxy config: %w", err)
+	}
+
+	return nil
+}
@@ -8,7 +8,6 @@ package krunkit
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 
@@ -19,7 +18,6 @@ import (
 	"bauklotze/pkg/system"
 
 	"bauklotze/pkg/machine/vmconfig"
-	"bauklotze/pkg/pty"
 	"bauklotze/pkg/registry"
 
 	"github.com/sirupsen/logrus"
@@ -64,6 +62,12 @@ func (l *Stubber) StartVMProvider(ctx context.Context, mc *vmconfig.MachineConfi
 	if err := machine.WaitPodmanReady(ctx, mc.PodmanSocks.InHost); err != nil {
 		logrus.Infof("vm podman service started")
 	}
+
+	if mc.DockerSocks != "" {
+		if err := machine.WaitDockerReady(ctx, mc.DockerSocks); err != nil {
+			logrus.Warnf("docker compatible api not ready: %v", err)
+		}
+	}
 	l.VMState.PodmanReady = true
 	events.NotifyRun(events.Ready)
 
@@ -101,15 +105,10 @@ func startKrunKit(ctx context.Context, mc *vmconfig.MachineConfig) error {
 	logrus.Infof("full cmdline: %q", cmd.Args)
 
 	events.NotifyRun(events.StartKrunKit)
-	ptmx, err := pty.RunInPty(cmd)
-	if err != nil {
-		return fmt.Errorf("failed to run krunkit in pty: %w", err)
+	if err := machine.RunVMMInPty(mc, cmd); err != nil {
+		return err //nolint:wrapcheck
 	}
 
-	go func() {
-		_, _ = io.Copy(os.Stdout, ptmx)
-	}()
-
 	if err := os.WriteFile(mc.PIDFiles.KrunKitPidFile, []byte(fmt.Sprintf("%d", cmd.Process.Pid)), 0644); err != nil {
 		return fmt.Errorf("unable to write krunkit pid file: %w", err)
 	}
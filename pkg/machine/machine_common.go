@@ -6,18 +6,20 @@ package machine
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"time"
 
 	"bauklotze/pkg/decompress"
 	"bauklotze/pkg/httpclient"
+	"bauklotze/pkg/machine/console"
 	"bauklotze/pkg/machine/define"
 	"bauklotze/pkg/machine/events"
 	"bauklotze/pkg/machine/fs"
-	"bauklotze/pkg/machine/ignition"
 	sshService "bauklotze/pkg/machine/ssh/service"
 	"bauklotze/pkg/machine/vmconfig"
 	"bauklotze/pkg/machine/volumes"
+	"bauklotze/pkg/ssh"
 
 	"github.com/containers/common/pkg/strongunits"
 	vfConfig "github.com/crc-org/vfkit/pkg/config"
@@ -30,20 +32,29 @@ const defaultPingTimeout = 5 * time.Second
 const defaultPingInterval = 200 * time.Millisecond
 
 func WaitPodmanReady(ctx context.Context, sock string) error {
+	return waitAPIReady(ctx, "Podman", sock)
+}
+
+// WaitDockerReady waits for the docker compatible api, the docker cli and compose ping it first
+func WaitDockerReady(ctx context.Context, sock string) error {
+	return waitAPIReady(ctx, "Docker", sock)
+}
+
+func waitAPIReady(ctx context.Context, name, sock string) error {
 	client := httpclient.New().SetTransport(httpclient.CreateUnixTransport(sock))
 	timeout := time.After(defaultPingTimeout)
 	for {
 		select {
 		case <-ctx.Done():
-			return fmt.Errorf("cancel WaitPodmanReady, ctx has been done: %w", context.Cause(ctx))
+			return fmt.Errorf("cancel Wait%sReady, ctx has been done: %w", name, context.Cause(ctx))
 		case <-timeout:
-			return fmt.Errorf("timeout reached while waiting for Podman API")
+			return fmt.Errorf("timeout reached while waiting for %s API", name)
 		default:
-			logrus.Info("Try ping Podman API")
+			logrus.Infof("Try ping %s API", name)
 			time.Sleep(defaultPingInterval)
 
 			if err := client.Get("_ping"); err == nil {
-				logrus.Infof("Podman ping test success")
+				logrus.Infof("%s ping test success", name)
 				return nil
 			}
 		}
@@ -55,20 +66,34 @@ var (
 	maxTried       = 100
 )
 
+// consoleTailLines is the number of console lines reported when the guest does not boot
+const consoleTailLines = 30
+
+// WaitSSHStarted waits for the guest sshd. If it does not start in time, the reported error event carries the
+// tail of the guest console, which is the only evidence of a boot which hangs before ssh.
 func WaitSSHStarted(ctx context.Context, mc *vmconfig.MachineConfig) bool {
+	var err error
 	for range maxTried {
 		if ctx.Err() != nil {
 			logrus.Warnf("cancel WaitSSHStarted, ctx has been done: %v", context.Cause(ctx))
 			return false
 		}
 
-		if err := sshService.GetKernelInfo(ctx, mc); err != nil {
+		var kernel string
+		kernel, err = sshService.GetKernelInfo(ctx, mc)
+		if err != nil {
 			logrus.Warnf("SSH readiness check err: %v, try again", err)
 			time.Sleep(defaultBackoff)
 			continue
 		}
+		logrus.Infof("SSH is ready, guest kernel: %s", kernel)
 		return true
 	}
+
+	err = fmt.Errorf("guest ssh service not started after %d tries: %w, last console output:\n%s",
+		maxTried, err, console.Output.Tail(consoleTailLines))
+	logrus.Warn(err)
+	events.NotifyError(err)
 	return false
 }
 
@@ -89,6 +114,10 @@ func InitializeVM(opts *vmconfig.VMOpts) (*vmconfig.MachineConfig, error) {
 		return nil, fmt.Errorf("create ssh key err: %w", err)
 	}
 
+	if err := mc.CreateHostKey(); err != nil {
+		return nil, fmt.Errorf("create ssh host key err: %w", err)
+	}
+
 	logrus.Infof("Decompress %q to %q", opts.BootImage, mc.Bootable.Path)
 
 	events.NotifyInit(events.ExtractBootImage)
@@ -162,10 +191,6 @@ func CreateDynamicConfigure(mc *vmconfig.MachineConfig) (*vfConfig.VirtualMachin
 
 	dynamicVMConfig.Devices = append(dynamicVMConfig.Devices, defaultDevices...)
 
-	if err = ignition.GenerateScripts(mc); err != nil {
-		return nil, fmt.Errorf("failed to generate ignition scripts: %w", err)
-	}
-
 	return dynamicVMConfig, nil
 }
 
@@ -227,13 +252,52 @@ func CreateAndResizeDisk(f string, sizeInGB int64, force bool) error {
 	return nil
 }
 
+// StartPodmanForward listens on the podman api sockets in the host, and forwards every connection
+// to the podman api socket in the guest. The sockets are created before it returns, the forwarding
+// stops when ctx is done.
+func StartPodmanForward(ctx context.Context, mc *vmconfig.MachineConfig) error {
+	sshClient, err := sshService.GuestClient(mc)
+	if err != nil {
+		return fmt.Errorf("failed to create ssh client: %w", err)
+	}
+
+	// the docker compatible socket is served by the same guest podman api
+	socks := []string{mc.PodmanSocks.InHost}
+	if mc.DockerSocks != "" {
+		socks = append(socks, mc.DockerSocks)
+	}
+
+	for _, sock := range socks {
+		if err := fs.NewFile(sock).DeleteInDir(vmconfig.Workspace); err != nil {
+			return fmt.Errorf("failed to remove socket file %q: %w", sock, err)
+		}
+
+		var lc net.ListenConfig
+		listener, err := lc.Listen(ctx, "unix", sock)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %q: %w", sock, err)
+		}
+
+		go func() {
+			if err := ssh.ForwardLocalUnix(ctx, sshClient, listener, mc.PodmanSocks.InGuest); err != nil {
+				logrus.Warnf("podman api forward on %q stop: %v", sock, err)
+			}
+		}()
+	}
+
+	return nil
+}
+
 func StartSSHAuthService(ctx context.Context, mc *vmconfig.MachineConfig) error {
+	sshClient, err := sshService.GuestClient(mc)
+	if err != nil {
+		return fmt.Errorf("failed to create ssh client: %w", err)
+	}
+
 	sshAuthService := sshService.NewSSHAuthService(
 		mc.SSHAuthSocks.LocalSocks,
 		mc.SSHAuthSocks.RemoteSocks,
-		mc.SSH.RemoteUsername,
-		mc.SSH.PrivateKeyPath,
-		mc.SSH.Port,
+		sshClient,
 	)
 
 	g, ctx2 := errgroup.WithContext(ctx)
@@ -0,0 +1,310 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package machine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"bauklotze/pkg/httpclient"
+	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/gvproxy"
+	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/port"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	podmanAPIPrefix     = "/v4.0.0/libpod"
+	publishRetryBackoff = 3 * time.Second
+)
+
+// podmanPort is a port mapping of a container, in the format of the libpod api
+type podmanPort struct {
+	HostIP        string `json:"host_ip"`
+	ContainerPort uint16 `json:"container_port"`
+	HostPort      uint16 `json:"host_port"`
+	Range         uint16 `json:"range"`
+	Protocol      string `json:"protocol"`
+}
+
+type podmanContainer struct {
+	ID    string       `json:"Id"`
+	Ports []podmanPort `json:"Ports"`
+}
+
+type podmanEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID string `json:"ID"`
+	} `json:"Actor"`
+}
+
+// portPublisher mirrors the published ports of the running containers to the host. The forwards only live
+// as long as the containers, they are not saved in the machine config.
+type portPublisher struct {
+	mc     *vmconfig.MachineConfig
+	client *http.Client
+	// published are the forwards exposed for each container id
+	published map[string][]vmconfig.PortForward
+}
+
+// PublishContainerPorts watches the podman events in guest, and exposes the published ports of the
+// containers on the host's 127.0.0.1 as long as the containers are running
+func PublishContainerPorts(ctx context.Context, mc *vmconfig.MachineConfig) error {
+	p := &portPublisher{
+		mc: mc,
+		// no timeout, the events are streamed
+		client:    &http.Client{Transport: httpclient.CreateUnixTransport(mc.PodmanSocks.InHost)},
+		published: make(map[string][]vmconfig.PortForward),
+	}
+
+	if err := p.waitReady(ctx); err != nil {
+		return err
+	}
+
+	for {
+		err := p.watch(ctx)
+		if ctx.Err() != nil {
+			return fmt.Errorf("cancel PublishContainerPorts, ctx has been done: %w", context.Cause(ctx))
+		}
+		logrus.Warnf("podman events stream stopped: %v, retry in %s", err, publishRetryBackoff)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cancel PublishContainerPorts, ctx has been done: %w", context.Cause(ctx))
+		case <-time.After(publishRetryBackoff):
+		}
+	}
+}
+
+// waitReady waits for the podman api quietly, WaitPodmanReady gives up after a few seconds
+// while podman may take longer on the first boot
+func (p *portPublisher) waitReady(ctx context.Context) error {
+	for {
+		resp, err := p.get(ctx, "/_ping", nil)
+		if err == nil {
+			_ = resp.Body.Close()
+			return nil
+		}
+		logrus.Debugf("podman api not ready for publishing ports: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cancel PublishContainerPorts, ctx has been done: %w", context.Cause(ctx))
+		case <-time.After(publishRetryBackoff):
+		}
+	}
+}
+
+func (p *portPublisher) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     define.LocalHostURL,
+		Path:     podmanAPIPrefix + path,
+		RawQuery: query.Encode(),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to do request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+// watch subscribes the container events, then reconciles the running containers, so no event is missed
+// between them. It returns when the stream ends.
+func (p *portPublisher) watch(ctx context.Context) error {
+	filters, _ := json.Marshal(map[string][]string{"type": {"container"}})
+	resp, err := p.get(ctx, "/events", url.Values{"stream": {"true"}, "filters": {string(filters)}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if err := p.reconcile(ctx); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var e podmanEvent
+		if err := dec.Decode(&e); err != nil {
+			return fmt.Errorf("decode podman event failed: %w", err)
+		}
+
+		switch e.Action {
+		case "start", "restart":
+			ctr, err := p.inspect(ctx, e.Actor.ID)
+			if err != nil {
+				logrus.Warnf("inspect container %s failed: %v", e.Actor.ID, err)
+				continue
+			}
+			p.publish(ctr)
+		case "died", "stop", "remove":
+			p.unpublish(e.Actor.ID)
+		}
+	}
+}
+
+// running lists the running containers
+func (p *portPublisher) running(ctx context.Context, id string) ([]podmanContainer, error) {
+	query := url.Values{}
+	if id != "" {
+		filters, _ := json.Marshal(map[string][]string{"id": {id}})
+		query.Set("filters", string(filters))
+	}
+
+	resp, err := p.get(ctx, "/containers/json", query)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	var ctrs []podmanContainer
+	if err := json.NewDecoder(resp.Body).Decode(&ctrs); err != nil {
+		return nil, fmt.Errorf("decode containers failed: %w", err)
+	}
+	return ctrs, nil
+}
+
+var errContainerNotRunning = errors.New("container is not running")
+
+func (p *portPublisher) inspect(ctx context.Context, id string) (*podmanContainer, error) {
+	ctrs, err := p.running(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(ctrs) == 0 {
+		return nil, errContainerNotRunning
+	}
+	return &ctrs[0], nil
+}
+
+// reconcile makes the forwards match the running containers
+func (p *portPublisher) reconcile(ctx context.Context) error {
+	ctrs, err := p.running(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	for id := range p.published {
+		if !slices.ContainsFunc(ctrs, func(c podmanContainer) bool { return c.ID == id }) {
+			p.unpublish(id)
+		}
+	}
+	for i := range ctrs {
+		p.publish(&ctrs[i])
+	}
+	return nil
+}
+
+// forwards returns the host forwards of the published ports of ctr. The guest listens on the host port,
+// which is forwarded from the same port of the host's loopback address.
+func forwards(ctr *podmanContainer) []vmconfig.PortForward {
+	var pfs []vmconfig.PortForward
+	for _, p := range ctr.Ports {
+		if p.HostPort == 0 {
+			continue
+		}
+		// podman joins the protocols of a mapping with a comma, e.g. tcp,udp
+		for _, protocol := range strings.Split(p.Protocol, ",") {
+			for i := range max(p.Range, 1) {
+				pf := vmconfig.PortForward{
+					Protocol:  protocol,
+					GuestPort: int(p.HostPort) + int(i),
+				}
+				pf.SetDefaults()
+				pfs = append(pfs, pf)
+			}
+		}
+	}
+	return pfs
+}
+
+// conflict returns why the host port of pf can not be published, or an empty string.
+// The forwards of the machine config and the ones gvproxy already serves take precedence.
+func (p *portPublisher) conflict(pf vmconfig.PortForward, active map[string]bool) string {
+	if active[gvproxy.ForwardKey(pf.Local(), pf.Protocol)] {
+		return "forwarded already"
+	}
+
+	p.mc.Lock()
+	saved := slices.ContainsFunc(p.mc.PortForwards, func(f vmconfig.PortForward) bool {
+		return f.HostPort == pf.HostPort && f.Protocol == pf.Protocol
+	})
+	p.mc.Unlock()
+	if saved {
+		return "reserved by a port forward of the machine"
+	}
+
+	if pf.Protocol == "tcp" && port.IsListening(pf.HostPort) || pf.Protocol == "udp" && port.IsUDPInUse(pf.HostPort) {
+		return "in use"
+	}
+	return ""
+}
+
+func (p *portPublisher) publish(ctr *podmanContainer) {
+	if _, ok := p.published[ctr.ID]; ok {
+		return
+	}
+
+	active, err := gvproxy.ActiveForwards(p.mc)
+	if err != nil {
+		logrus.Warnf("list forwards failed, skip publishing ports of container %.12s: %v", ctr.ID, err)
+		return
+	}
+
+	var exposed []vmconfig.PortForward
+	for _, pf := range forwards(ctr) {
+		if pf.Protocol != "tcp" && pf.Protocol != "udp" {
+			continue
+		}
+		if reason := p.conflict(pf, active); reason != "" {
+			logrus.Warnf("host port %d/%s is %s, skip publishing it for container %.12s", pf.HostPort, pf.Protocol, reason, ctr.ID)
+			continue
+		}
+		if err := gvproxy.Expose(p.mc, pf); err != nil {
+			logrus.Warnf("publish port of container %.12s failed: %v", ctr.ID, err)
+			continue
+		}
+		logrus.Infof("published %s/%s -> %s for container %.12s", pf.Local(), pf.Protocol, pf.Remote(), ctr.ID)
+		exposed = append(exposed, pf)
+	}
+	p.published[ctr.ID] = exposed
+}
+
+func (p *portPublisher) unpublish(id string) {
+	pfs, ok := p.published[id]
+	if !ok {
+		return
+	}
+	delete(p.published, id)
+
+	for _, pf := range pfs {
+		if err := gvproxy.Unexpose(p.mc, pf); err != nil {
+			logrus.Warnf("unpublish port of container %.12s failed: %v", id, err)
+			continue
+		}
+		logrus.Infof("unpublished %s/%s for container %.12s", pf.Local(), pf.Protocol, id)
+	}
+}
@@ -14,6 +14,8 @@ import (
 	"bauklotze/pkg/machine/define"
 	"bauklotze/pkg/machine/disk"
 	"bauklotze/pkg/machine/events"
+	"bauklotze/pkg/machine/gvproxy"
+	"bauklotze/pkg/machine/ignition"
 	"bauklotze/pkg/machine/krunkit"
 	"bauklotze/pkg/machine/ssh/service"
 	"bauklotze/pkg/machine/vfkit"
@@ -44,6 +46,14 @@ func Update(mc *vmconfig.MachineConfig, opts *vmconfig.VMOpts) (*vmconfig.Machin
 	mc.Resources.CPUs = opts.CPUs
 	mc.Resources.MemoryInMB = opts.MemoryInMiB
 	mc.Mounts = volumes.CmdLineVolumesToMounts(opts.Volumes)
+	mc.SetIgnitionMount()
+	mc.Provision.Scripts = vmconfig.ProvisionScriptsFromCmdLine(opts.ProvisionScripts)
+	mc.CACerts = opts.CACerts
+	mc.Proxy = opts.Proxy
+	mc.Registries = opts.Registries
+	mc.Exec = opts.Exec
+	mc.AutoPublishPorts = opts.AutoPublishPorts
+	mc.SetDockerSocks(opts.DockerSocket)
 
 	if mc.Bootable.Version != opts.BootVersion {
 		logrus.Infof("Bootable image version is not match, try to update boot image")
@@ -51,6 +61,11 @@ func Update(mc *vmconfig.MachineConfig, opts *vmconfig.VMOpts) (*vmconfig.Machin
 			return nil, fmt.Errorf("update boot image failed: %w", err)
 		}
 		mc.Bootable.Version = opts.BootVersion
+
+		// a new boot image may come with its own host keys, never trust the old fingerprint for it
+		if err := mc.CreateHostKey(); err != nil {
+			return nil, fmt.Errorf("rotate ssh host key failed: %w", err)
+		}
 	}
 
 	if mc.DataDisk.Version != opts.DataVersion {
@@ -83,7 +98,7 @@ func RaceWait(cmds ...*exec.Cmd) error {
 // Start starts the VM provider.
 // 1. Start the network stack
 // 2. Start the VM provider
-// 3. Start the SSH auth and TimeSync service
+// 3. Start the SSH auth, TimeSync and container ports publisher service
 func Start(parentCtx context.Context, mc *vmconfig.MachineConfig, vmp vmconfig.VMProvider) error {
 	ctx, cancel := context.WithCancelCause(context.Background())
 	context.AfterFunc(parentCtx, func() {
@@ -102,13 +117,24 @@ func Start(parentCtx context.Context, mc *vmconfig.MachineConfig, vmp vmconfig.V
 	if err := vmp.StartNetworkProvider(ctx, mc); err != nil {
 		return fmt.Errorf("failed to start network stack: %w", err)
 	}
+	gvproxy.ApplyPortForwards(ctx, mc)
+
+	if err := machine.StartPodmanForward(ctx, mc); err != nil {
+		return fmt.Errorf("failed to forward podman api: %w", err)
+	}
 
 	// 2. extract the source code disk
 	if err := disk.ExtractSourceCodeDisk(ctx, filepath.Dir(mc.GetSourceDiskPath()), false); err != nil {
 		return fmt.Errorf("failed to extract source code disk: %w", err)
 	}
 
-	// 3. Start the VM provider
+	// 3. Generate the ignition script, the guest runs it on boot
+	hooks, err := ignition.GenerateScripts(mc)
+	if err != nil {
+		return fmt.Errorf("failed to generate ignition scripts: %w", err)
+	}
+
+	// 4. Start the VM provider
 	if err := vmp.StartVMProvider(ctx, mc); err != nil {
 		return fmt.Errorf("failed to start vm provider: %w", err)
 	}
@@ -121,11 +147,26 @@ func Start(parentCtx context.Context, mc *vmconfig.MachineConfig, vmp vmconfig.V
 		}
 	}()
 
+	go func() {
+		if err := ignition.WatchProvisionHooks(ctx, mc, hooks); err != nil {
+			logrus.Warnf("provision hooks watcher stop: %v", err)
+		}
+	}()
+
 	go func() {
 		if err := machine.SyncTimeOnWake(ctx, mc); err != nil {
 			logrus.Warnf("time sync service stop: %v", err)
 		}
 	}()
 
+	if mc.AutoPublishPorts {
+		go func() {
+			logrus.Infof("Start container ports publisher")
+			if err := machine.PublishContainerPorts(ctx, mc); err != nil {
+				logrus.Warnf("container ports publisher stop: %v", err)
+			}
+		}()
+	}
+
 	return nil
 }
@@ -6,6 +6,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"bauklotze/pkg/machine/define"
 	"bauklotze/pkg/machine/vmconfig"
@@ -15,21 +16,104 @@ import (
 	sshSingal "golang.org/x/crypto/ssh"
 )
 
-func runCtx(ctx context.Context, mc *vmconfig.MachineConfig, name string, args []string) error {
-	sshConfig, err := ssh.NewConfig(define.LocalHostURL, mc.SSH.RemoteUsername, uint(mc.SSH.Port), mc.SSH.PrivateKeyPath)
+// NewSSHConfig returns the config of the guest sshd, the host key is verified against the recorded fingerprint
+func NewSSHConfig(mc *vmconfig.MachineConfig) (*ssh.Config, error) {
+	sshConfig, err := ssh.NewConfig(define.LocalHostURL, mc.SSH.RemoteUsername, uint(mc.SSH.Port), mc.SSH.PrivateKeyPath, mc.SSH.HostKeyFingerprint)
 	if err != nil {
-		return fmt.Errorf("failed to create ssh config: %w", err)
+		return nil, fmt.Errorf("failed to create ssh config: %w", err)
 	}
-	myCmd := ssh.NewCmd(sshConfig)
+	return sshConfig, nil
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = make(map[string]*guestClient)
+)
+
+type guestClient struct {
+	// key changes if the ssh port or the host key of the machine changes
+	key    string
+	client *ssh.Client
+}
+
+// GuestClient returns the ssh client shared by all the operations on the guest of mc
+func GuestClient(mc *vmconfig.MachineConfig) (*ssh.Client, error) {
+	key := fmt.Sprintf("%d|%s|%s", mc.SSH.Port, mc.SSH.RemoteUsername, mc.SSH.HostKeyFingerprint)
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if c, ok := clients[mc.ConfigFile]; ok {
+		if c.key == key {
+			return c.client, nil
+		}
+		_ = c.client.Close()
+		delete(clients, mc.ConfigFile)
+	}
+
+	sshConfig, err := NewSSHConfig(mc)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &guestClient{key: key, client: ssh.NewClient(sshConfig)}
+	clients[mc.ConfigFile] = c
+	return c.client, nil
+}
+
+// GuestSSHHealth returns the state of the shared ssh connection of mc
+func GuestSSHHealth(mc *vmconfig.MachineConfig) ssh.Health {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if c, ok := clients[mc.ConfigFile]; ok {
+		return c.client.Health()
+	}
+	return ssh.Health{}
+}
+
+// NewCmd returns a command which runs in the guest of mc, it is killed when ctx is canceled
+func NewCmd(ctx context.Context, mc *vmconfig.MachineConfig, name string, args []string) (*ssh.Cmd, error) {
+	client, err := GuestClient(mc)
+	if err != nil {
+		return nil, err
+	}
+	myCmd := ssh.NewCmd(client)
 	myCmd.SetCmdLine(ctx, name, args)
 
 	myCmd.SetStopSignal(sshSingal.SIGKILL)
+	return myCmd, nil
+}
 
+// runResult runs the command, a non-zero exit is returned as an error
+func runResult(myCmd *ssh.Cmd) (*ssh.Result, error) {
 	logrus.Infof("SSH client running command: %s", myCmd.String())
-	return myCmd.RunCtx() //nolint:wrapcheck
+	result, err := myCmd.Run()
+	if err != nil {
+		return result, err //nolint:wrapcheck
+	}
+
+	logrus.Infof("SSH command finished in %s with exit code %d", result.Duration, result.ExitCode)
+	if len(result.Stdout) > 0 {
+		logrus.Debugf("stdout: %s", result.Stdout)
+	}
+	if len(result.Stderr) > 0 {
+		logrus.Infof("stderr: %s", result.Stderr)
+	}
+
+	return result, result.Err() //nolint:wrapcheck
+}
+
+func runCtx(ctx context.Context, mc *vmconfig.MachineConfig, name string, args []string) (*ssh.Result, error) {
+	myCmd, err := NewCmd(ctx, mc, name, args)
+	if err != nil {
+		return nil, err
+	}
+	return runResult(myCmd)
 }
 
 func run(mc *vmconfig.MachineConfig, name string, args []string) error {
 	ctx := context.Background()
-	return runCtx(ctx, mc, name, args)
+	_, err := runCtx(ctx, mc, name, args)
+	return err
 }
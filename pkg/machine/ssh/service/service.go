@@ -4,26 +4,73 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/shell"
 )
 
-func GetKernelInfo(ctx context.Context, mc *vmconfig.MachineConfig) error {
-	return runCtx(ctx, mc, "uname", []string{
+const (
+	probeTimeout     = 10 * time.Second
+	fileWriteTimeout = 30 * time.Second
+)
+
+// GetKernelInfo returns the output of uname -a, it is used as the ssh readiness probe
+func GetKernelInfo(ctx context.Context, mc *vmconfig.MachineConfig) (string, error) {
+	myCmd, err := NewCmd(ctx, mc, "uname", []string{
 		"-a",
 	})
+	if err != nil {
+		return "", err
+	}
+	myCmd.SetTimeout(probeTimeout)
+
+	result, err := runResult(myCmd)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(result.Stdout)), nil
+}
+
+// WriteFile writes content into the guest file, the parent directories are created if missing
+func WriteFile(ctx context.Context, mc *vmconfig.MachineConfig, file string, content []byte, perm os.FileMode) error {
+	script := fmt.Sprintf("mkdir -p %s && cat > %s && chmod %04o %s",
+		shell.Quote(path.Dir(file)),
+		shell.Quote(file),
+		perm,
+		shell.Quote(file),
+	)
+	myCmd, err := NewCmd(ctx, mc, "sh", []string{"-c", script})
+	if err != nil {
+		return err
+	}
+	myCmd.SetStdin(bytes.NewReader(content))
+	myCmd.SetTimeout(fileWriteTimeout)
+
+	_, err = runResult(myCmd)
+	return err
+}
+
+// RemoveFiles removes the guest files, missing files are ignored
+func RemoveFiles(ctx context.Context, mc *vmconfig.MachineConfig, files ...string) error {
+	_, err := runCtx(ctx, mc, "rm", append([]string{"-f"}, files...))
+	return err
 }
 
 func DoTimeSync(ctx context.Context, mc *vmconfig.MachineConfig) error {
-	return runCtx(ctx, mc, "date", []string{
+	_, err := runCtx(ctx, mc, "date", []string{
 		"-s",
 		fmt.Sprintf("@%d", time.Now().Unix()),
 	})
+	return err
 }
 
 func DoSync(mc *vmconfig.MachineConfig) error {
@@ -7,36 +7,31 @@ import (
 	"context"
 	"fmt"
 
-	"bauklotze/pkg/machine/define"
 	"bauklotze/pkg/machine/fs"
 	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/ssh"
 
 	"github.com/oomol-lab/ovm-ssh-agent/v3/pkg/identity"
 	"github.com/oomol-lab/ovm-ssh-agent/v3/pkg/sshagent"
 	system2 "github.com/oomol-lab/ovm-ssh-agent/v3/pkg/system"
-	forwarder "github.com/oomol-lab/ssh-forward"
 	"github.com/sirupsen/logrus"
 )
 
 type SSHAuthService struct {
 	localSocks               string
 	remoteSocks              string
-	sshUser                  string
-	sshAuthKey               string
-	sshPort                  int
+	sshClient                *ssh.Client
 	errChanSSHAuth           chan error
 	errChanUnixSocketForward chan error
 }
 
 // NewSSHAuthService a ssh agent forward service.
 // listen a local socks file and forward to the upstream ssh agent socks.
-func NewSSHAuthService(localSocks, remoteSocks, user, key string, port int) *SSHAuthService {
+func NewSSHAuthService(localSocks, remoteSocks string, sshClient *ssh.Client) *SSHAuthService {
 	return &SSHAuthService{
 		localSocks:               localSocks,
 		remoteSocks:              remoteSocks,
-		sshUser:                  user,
-		sshAuthKey:               key,
-		sshPort:                  port,
+		sshClient:                sshClient,
 		errChanSSHAuth:           make(chan error, 1),
 		errChanUnixSocketForward: make(chan error, 1),
 	}
@@ -63,21 +58,5 @@ func (s *SSHAuthService) StartSSHAuthServiceAndForwardV2(ctx context.Context) er
 
 func (s *SSHAuthService) StartUnixSocketForward(ctx context.Context) error {
 	logrus.Infof("forward unix socket %q to %q", s.localSocks, s.remoteSocks)
-	socketForwarder := forwarder.NewUnixRemote(s.localSocks, define.LocalHostURL, s.remoteSocks).
-		SetKeyFile(s.sshAuthKey).
-		SetUser(s.sshUser).
-		SetPort(s.sshPort)
-
-	socketForwarder.SetConnState(func(tun *forwarder.ForwardConfig, state forwarder.ConnState) {
-		switch state {
-		case forwarder.StateStarting:
-			logrus.Infoln("socket forwarder state is: starting")
-		case forwarder.StateStarted:
-			logrus.Infoln("socket forwarder state is: started")
-		case forwarder.StateStopped:
-			logrus.Infoln("socket forwarder state is: stopped")
-		}
-	})
-
-	return socketForwarder.Start(ctx) //nolint:wrapcheck
+	return ssh.ForwardRemoteUnix(ctx, s.sshClient, s.remoteSocks, s.localSocks) //nolint:wrapcheck
 }
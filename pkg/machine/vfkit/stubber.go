@@ -8,7 +8,6 @@ package vfkit
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 
@@ -17,7 +16,6 @@ import (
 	"bauklotze/pkg/machine/events"
 	"bauklotze/pkg/machine/gvproxy"
 	"bauklotze/pkg/machine/vmconfig"
-	"bauklotze/pkg/pty"
 	"bauklotze/pkg/registry"
 	"bauklotze/pkg/system"
 
@@ -63,6 +61,12 @@ func (l *Stubber) StartVMProvider(ctx context.Context, mc *vmconfig.MachineConfi
 		logrus.Infof("vm podman service started")
 	}
 
+	if mc.DockerSocks != "" {
+		if err := machine.WaitDockerReady(ctx, mc.DockerSocks); err != nil {
+			logrus.Warnf("docker compatible api not ready: %v", err)
+		}
+	}
+
 	l.VMState.PodmanReady = true
 	events.NotifyRun(events.Ready)
 
@@ -100,15 +104,10 @@ func startVFkit(ctx context.Context, mc *vmconfig.MachineConfig) error {
 	logrus.Infof("full cmdline: %q", cmd.Args)
 
 	events.NotifyRun(events.StartVFKit)
-	ptmx, err := pty.RunInPty(cmd)
-	if err != nil {
-		return fmt.Errorf("failed to run krunkit in pty: %w", err)
+	if err := machine.RunVMMInPty(mc, cmd); err != nil {
+		return err //nolint:wrapcheck
 	}
 
-	go func() {
-		_, _ = io.Copy(os.Stdout, ptmx)
-	}()
-
 	if err := os.WriteFile(mc.PIDFiles.VFKitPidFile, []byte(fmt.Sprintf("%d", cmd.Process.Pid)), 0644); err != nil {
 		return fmt.Errorf("unable to write krunkit pid file: %w", err)
 	}
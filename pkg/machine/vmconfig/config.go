@@ -31,6 +31,15 @@ type VMOpts struct {
 	ReInit      bool
 	ReportURL   string
 	VMM         string
+	// ProvisionScripts are the raw --provision-script values
+	ProvisionScripts []string
+	CACerts          []string
+	Proxy            ProxyConfig
+	Registries       RegistriesConfig
+	Exec             ExecLimits
+	AutoPublishPorts bool
+	// DockerSocket forwards a docker.sock to the guest podman
+	DockerSocket bool
 }
 
 func (opts *VMOpts) GetVMConfigPath() string {
@@ -8,9 +8,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 
 	"bauklotze/pkg/machine/define"
@@ -18,6 +23,7 @@ import (
 
 	"bauklotze/pkg/machine/volumes"
 	"bauklotze/pkg/port"
+	"bauklotze/pkg/ssh"
 
 	"github.com/containers/storage/pkg/ioutils"
 	"github.com/go-playground/validator/v10"
@@ -65,7 +71,39 @@ func (mc *MachineConfig) MakeDirs() error {
 		return err //nolint:wrapcheck
 	}
 
-	return os.MkdirAll(mc.Dirs.PidsDir, os.ModePerm) //nolint:wrapcheck
+	if err := os.MkdirAll(mc.Dirs.PidsDir, os.ModePerm); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	return mc.MakeIgnitionDir()
+}
+
+// MakeIgnitionDir creates the ignition dir, it holds the ssh public key and the provisioning
+// scripts so only the owner can access it
+func (mc *MachineConfig) MakeIgnitionDir() error {
+	if err := os.MkdirAll(mc.Dirs.IgnitionDir, 0700); err != nil { //nolint:mnd
+		return err //nolint:wrapcheck
+	}
+	// the dir may be created by an older version with a wider permission
+	return os.Chmod(mc.Dirs.IgnitionDir, 0700) //nolint:wrapcheck,mnd
+}
+
+// SetIgnitionMount shares the ignition dir into the guest, replacing any mount of the guest ignition dir,
+// configs created by older versions share the global /tmp/initfs instead
+func (mc *MachineConfig) SetIgnitionMount() {
+	if mc.Dirs.IgnitionDir == "" {
+		mc.Dirs.IgnitionDir = filepath.Join(Workspace, mc.VMName, define.IgnPrefixDir)
+	}
+
+	ignMount := volumes.CmdLineVolumesToMounts([]string{mc.Dirs.IgnitionDir + ":" + define.IgnGuestDir})[0]
+	mounts := make([]volumes.Mount, 0, len(mc.Mounts)+1)
+	for _, m := range mc.Mounts {
+		if m.Target == ignMount.Target {
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+	mc.Mounts = append(mounts, ignMount)
 }
 
 func (mc *MachineConfig) CreateSSHKey() error {
@@ -88,12 +126,68 @@ func (mc *MachineConfig) CreateSSHKey() error {
 	return cmd.Run() //nolint:wrapcheck
 }
 
+// GetHostKeyPath returns the guest host key path, configs created by older versions have none recorded
+func (mc *MachineConfig) GetHostKeyPath() string {
+	if mc.SSH.HostKeyPath == "" {
+		return filepath.Join(mc.Dirs.DataDir, define.SSHHostKey)
+	}
+	return mc.SSH.HostKeyPath
+}
+
+// CreateHostKey generates a new guest host key and records its fingerprint, the previous key is replaced
+func (mc *MachineConfig) CreateHostKey() error {
+	mc.SSH.HostKeyPath = mc.GetHostKeyPath()
+
+	fingerprint, err := ssh.GenerateHostKey(mc.SSH.HostKeyPath)
+	if err != nil {
+		return fmt.Errorf("generate ssh host key err: %w", err)
+	}
+	mc.SSH.HostKeyFingerprint = fingerprint
+	logrus.Infof("ssh host key %q generated: %s", mc.SSH.HostKeyPath, fingerprint)
+	return nil
+}
+
+// EnsureHostKey makes sure the guest host key exists and matches the recorded fingerprint,
+// configs created by older versions have no host key. The machine config is saved if changed.
+func (mc *MachineConfig) EnsureHostKey() error {
+	if mc.SSH.HostKeyPath != "" && mc.SSH.HostKeyFingerprint != "" {
+		fingerprint, err := ssh.HostKeyFingerprint(mc.SSH.HostKeyPath)
+		if err == nil && fingerprint == mc.SSH.HostKeyFingerprint {
+			return nil
+		}
+		logrus.Warnf("ssh host key %q is missing or changed, generate a new one", mc.SSH.HostKeyPath)
+	}
+
+	if err := mc.CreateHostKey(); err != nil {
+		return err
+	}
+	return mc.Write()
+}
+
 // GetNetworkStackEndpoint return the unix socket path for network stack endpoint which provided by gvproxy.
 // the NetworkStackEndpoint provides the network stack for vm
 func (mc *MachineConfig) GetNetworkStackEndpoint() string {
 	return fs.NewFile(mc.Dirs.SocksDir).AppendFile(define.GvProxyEndPoint).GetPath()
 }
 
+// SetDockerSocks enables or disables the docker compatible api socket
+func (mc *MachineConfig) SetDockerSocks(enable bool) {
+	mc.DockerSocks = ""
+	if enable {
+		mc.DockerSocks = filepath.Join(mc.Dirs.SocksDir, define.DockerHostSocksName)
+	}
+}
+
+// GetGvproxyServicesEndpoint returns the unix socket of the gvproxy services api
+func (mc *MachineConfig) GetGvproxyServicesEndpoint() string {
+	return fs.NewFile(mc.Dirs.SocksDir).AppendFile(define.GvProxyServices).GetPath()
+}
+
+// GetLogPath returns the path of the log file name in the logs directory of the machine
+func (mc *MachineConfig) GetLogPath(name string) string {
+	return fs.NewFile(mc.Dirs.LogsDir).AppendFile(name).GetPath()
+}
+
 func (mc *MachineConfig) GetSSHPort() error {
 	if port.IsListening(mc.SSH.Port) {
 		logrus.Warnf("%d not available, try to allocate a free port for ssh", mc.SSH.Port)
@@ -114,26 +208,58 @@ type MachineDirs struct {
 	PidsDir   string `json:"pidsDir"   validate:"required,dir"`
 	LogsDir   string `json:"logsDir"   validate:"required,dir"`
 	SocksDir  string `json:"socksDir"  validate:"required,dir"`
+	// IgnitionDir is empty in configs created by older versions, see SetIgnitionMount
+	IgnitionDir string `json:"ignitionDir,omitempty"`
 }
 
 type MachineConfig struct {
-	VMType       string          `json:"vmType"              validate:"required"`
-	Dirs         MachineDirs     `json:"dirs"                validate:"required"`
-	VMName       string          `json:"name"                validate:"required"`
-	Bootable     Bootable        `json:"bootable"            validate:"required"`
-	DataDisk     DataDisk        `json:"dataDisk"            validate:"required"`
-	ConfigFile   string          `json:"configFile"          validate:"required"`
-	Resources    ResourceConfig  `json:"resources"`
-	Mounts       []volumes.Mount `json:"mounts"`
-	SSH          SSHConfig       `json:"ssh"                 validate:"required"`
-	ReportURL    string          `json:"reportURL,omitempty"`
-	PodmanSocks  podmanSocks     `json:"podmanSocks"         validate:"required"`
-	PIDFiles     pidFiles        `json:"pidFiles"`
-	SSHAuthSocks SSHAuthSocks    `json:"sshAuthSocks"        validate:"required"`
+	VMType      string          `json:"vmType"              validate:"required"`
+	Dirs        MachineDirs     `json:"dirs"                validate:"required"`
+	VMName      string          `json:"name"                validate:"required"`
+	Bootable    Bootable        `json:"bootable"            validate:"required"`
+	DataDisk    DataDisk        `json:"dataDisk"            validate:"required"`
+	ConfigFile  string          `json:"configFile"          validate:"required"`
+	Resources   ResourceConfig  `json:"resources"`
+	Mounts      []volumes.Mount `json:"mounts"`
+	SSH         SSHConfig       `json:"ssh"                 validate:"required"`
+	ReportURL   string          `json:"reportURL,omitempty"`
+	PodmanSocks podmanSocks     `json:"podmanSocks"         validate:"required"`
+	// DockerSocks is the docker compatible api socket in host, it is empty if not enabled
+	DockerSocks  string       `json:"dockerSocks,omitempty"`
+	PIDFiles     pidFiles     `json:"pidFiles"`
+	SSHAuthSocks SSHAuthSocks `json:"sshAuthSocks"        validate:"required"`
+	Provision    Provision    `json:"provision"`
+	// CACerts are host PEM bundles installed into the guest trust store
+	CACerts []string    `json:"caCerts,omitempty"`
+	Proxy   ProxyConfig `json:"proxy"`
+	// Registries configures the container registries used by podman in guest
+	Registries RegistriesConfig `json:"registries"`
+	// Exec limits the commands run by the rest api in guest
+	Exec ExecLimits `json:"exec"`
+	// PortForwards expose guest ports on the host, they are re-applied on every start
+	PortForwards []PortForward `json:"portForwards,omitempty"`
+	// AutoPublishPorts exposes the published ports of the running containers on the host
+	AutoPublishPorts bool `json:"autoPublishPorts"`
+	// GvproxyDebug runs gvproxy in debug mode, it takes effect on the next start
+	GvproxyDebug bool `json:"gvproxyDebug,omitempty"`
 
 	// RestAPISocks is the socks for rest api, it is used by appliance to connect to query the status of vm
 	// exec cmdline in vm etc...
 	RestAPISocks string `json:"restAPISocks" validate:"required"`
+
+	// mu serializes the updates of the config at runtime, see Lock
+	mu sync.Mutex
+}
+
+// Lock must be held while the config is read or updated after the machine is started,
+// the rest api and the provision hooks may modify it concurrently
+func (mc *MachineConfig) Lock() {
+	mc.mu.Lock()
+}
+
+// Unlock releases the lock acquired by Lock
+func (mc *MachineConfig) Unlock() {
+	mc.mu.Unlock()
 }
 
 type SSHAuthSocks struct {
@@ -172,6 +298,232 @@ type SSHConfig struct {
 	PublicKeyPath  string `json:"publicKeyPath"  validate:"required,file"`
 	Port           int    `json:"port"           validate:"required"`
 	RemoteUsername string `json:"remoteUsername" validate:"required"`
+	// HostKeyPath is the guest sshd host key, it is generated in host and injected by ignition
+	HostKeyPath string `json:"hostKeyPath,omitempty"`
+	// HostKeyFingerprint is the SHA256 fingerprint every ssh connection to the guest must present
+	HostKeyFingerprint string `json:"hostKeyFingerprint,omitempty"`
+}
+
+// ProxyConfig is the HTTP proxy used by podman and the shell in guest.
+// Explicit values take precedence over the values detected from the environment.
+type ProxyConfig struct {
+	// FromEnv detects HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the environment of the start process
+	FromEnv    bool   `json:"fromEnv,omitempty"`
+	HTTPProxy  string `json:"httpProxy,omitempty"`
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+	NoProxy    string `json:"noProxy,omitempty"`
+}
+
+// RedactedMarker replaces the credentials in the output which may be shared, e.g. the support bundle
+const RedactedMarker = "<redacted>"
+
+// Redacted returns p with the credentials of the proxies replaced, see RedactProxy
+func (p ProxyConfig) Redacted() ProxyConfig {
+	p.HTTPProxy = RedactProxy(p.HTTPProxy)
+	p.HTTPSProxy = RedactProxy(p.HTTPSProxy)
+	return p
+}
+
+// ParseProxy parses a proxy setting, the scheme is optional as in the environment variables, e.g. user:pass@proxy:3128.
+// The second result reports whether the scheme was missing
+func ParseProxy(proxy string) (*url.URL, bool) {
+	raw := proxy
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, false
+	}
+	return u, raw != proxy
+}
+
+// RedactProxy replaces the userinfo of the proxy, a proxy which can not be parsed is redacted completely
+func RedactProxy(proxy string) string {
+	if proxy == "" {
+		return ""
+	}
+	u, noScheme := ParseProxy(proxy)
+	if u == nil {
+		return RedactedMarker
+	}
+	if u.User == nil {
+		return proxy
+	}
+
+	u.User = nil
+	s := strings.Replace(u.String(), "://", "://"+RedactedMarker+"@", 1)
+	if noScheme {
+		return strings.TrimPrefix(s, "http://")
+	}
+	return s
+}
+
+// RegistriesConfig is rendered into the guest's registries.conf.d and auth.json
+type RegistriesConfig struct {
+	Mirrors []RegistryMirror `json:"mirrors,omitempty"  validate:"dive"`
+	// Insecure registries are accessed over plain HTTP or with unverified TLS
+	Insecure []string `json:"insecure,omitempty" validate:"dive,required"`
+	// Search registries are used to resolve unqualified image names
+	Search []string `json:"search,omitempty"   validate:"dive,required"`
+	// AuthFile is a host auth.json which is copied into the guest
+	AuthFile string `json:"authFile,omitempty" validate:"omitempty,file"`
+}
+
+// ExecLimits bounds the ssh sessions and the host memory used by /exec, /exec/attach and /jobs.
+// Zero values mean the defaults, see WithDefaults.
+type ExecLimits struct {
+	// MaxSessions is the number of commands running at the same time
+	MaxSessions int `json:"maxSessions,omitempty"`
+	// QueueLength is the number of requests waiting for a session, more requests are rejected at once
+	QueueLength int `json:"queueLength,omitempty"`
+	// QueueTimeout in seconds is how long a request waits for a session
+	QueueTimeout int `json:"queueTimeout,omitempty"`
+	// OutputBuffer in bytes is the output of a command kept in host before the client reads it,
+	// the command is paused when it is full
+	OutputBuffer int `json:"outputBuffer,omitempty"`
+	// MaxJobs is the number of background jobs running at the same time, they do not take exec sessions
+	MaxJobs int `json:"maxJobs,omitempty"`
+}
+
+const (
+	defaultExecMaxSessions  = 8
+	defaultExecQueueLength  = 32
+	defaultExecQueueTimeout = 30
+	defaultExecOutputBuffer = 256 << 10
+	defaultExecMaxJobs      = 16
+)
+
+// WithDefaults returns l with the zero values replaced by the defaults
+func (l ExecLimits) WithDefaults() ExecLimits {
+	if l.MaxSessions <= 0 {
+		l.MaxSessions = defaultExecMaxSessions
+	}
+	if l.QueueLength <= 0 {
+		l.QueueLength = defaultExecQueueLength
+	}
+	if l.QueueTimeout <= 0 {
+		l.QueueTimeout = defaultExecQueueTimeout
+	}
+	if l.OutputBuffer <= 0 {
+		l.OutputBuffer = defaultExecOutputBuffer
+	}
+	if l.MaxJobs <= 0 {
+		l.MaxJobs = defaultExecMaxJobs
+	}
+	return l
+}
+
+// PortForward exposes a guest port on a host address
+type PortForward struct {
+	// Protocol is tcp or udp
+	Protocol  string `json:"protocol"  validate:"oneof=tcp udp"`
+	HostIP    string `json:"hostIP"    validate:"ip"`
+	HostPort  int    `json:"hostPort"  validate:"min=1,max=65535"`
+	GuestPort int    `json:"guestPort" validate:"min=1,max=65535"`
+}
+
+// SetDefaults fills the protocol and the host address, the host port defaults to the guest port
+func (p *PortForward) SetDefaults() {
+	if p.Protocol == "" {
+		p.Protocol = "tcp"
+	}
+	if p.HostIP == "" {
+		p.HostIP = define.LocalHostURL
+	}
+	if p.HostPort == 0 {
+		p.HostPort = p.GuestPort
+	}
+}
+
+func (p *PortForward) Validate() error {
+	return validator.New(validator.WithRequiredStructEnabled()).Struct(p) //nolint:wrapcheck
+}
+
+// Local is the host address of the forward, it identifies the forward
+func (p *PortForward) Local() string {
+	return net.JoinHostPort(p.HostIP, strconv.Itoa(p.HostPort))
+}
+
+// Remote is the guest address of the forward
+func (p *PortForward) Remote() string {
+	return net.JoinHostPort(define.GuestIP, strconv.Itoa(p.GuestPort))
+}
+
+type RegistryMirror struct {
+	Registry string   `json:"registry" validate:"required"`
+	Mirrors  []string `json:"mirrors"  validate:"required,dive,required"`
+}
+
+// RegistryMirrorsFromCmdLine parses the --registry-mirror values, in format of registry=mirror1,mirror2
+func RegistryMirrorsFromCmdLine(values []string) ([]RegistryMirror, error) {
+	mirrors := make([]RegistryMirror, 0, len(values))
+	for _, v := range values {
+		registry, list, ok := strings.Cut(v, "=")
+		if !ok || registry == "" || list == "" {
+			return nil, fmt.Errorf("invalid registry mirror %q, expect registry=mirror1,mirror2", v)
+		}
+		mirrors = append(mirrors, RegistryMirror{
+			Registry: registry,
+			Mirrors:  strings.Split(list, ","),
+		})
+	}
+	return mirrors, nil
+}
+
+// Validate checks the registries configuration, it is used for the values received from the rest api
+func (r *RegistriesConfig) Validate() error {
+	return validator.New(validator.WithRequiredStructEnabled()).Struct(r) //nolint:wrapcheck
+}
+
+// Provision contains the user supplied provisioning hooks, which are appended to the ignition script
+type Provision struct {
+	// Scripts given by --provision-script, in command line order
+	Scripts []ProvisionScript `json:"scripts,omitempty"`
+	// Done contains the digests of run-once hooks which already finished successfully
+	Done []string `json:"done,omitempty"`
+}
+
+type ProvisionScript struct {
+	Path string `json:"path"`
+	Once bool   `json:"once,omitempty"`
+}
+
+const (
+	provisionOncePrefix   = "once:"
+	provisionAlwaysPrefix = "always:"
+)
+
+// ProvisionScriptsFromCmdLine parses the --provision-script values.
+// A value prefixed with "once:" only runs until it succeeded once, "always:" or no prefix runs on every boot.
+func ProvisionScriptsFromCmdLine(values []string) []ProvisionScript {
+	scripts := make([]ProvisionScript, 0, len(values))
+	for _, v := range values {
+		script := ProvisionScript{Path: v}
+		switch {
+		case strings.HasPrefix(v, provisionOncePrefix):
+			script.Path = strings.TrimPrefix(v, provisionOncePrefix)
+			script.Once = true
+		case strings.HasPrefix(v, provisionAlwaysPrefix):
+			script.Path = strings.TrimPrefix(v, provisionAlwaysPrefix)
+		}
+
+		if script.Path == "" {
+			continue
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts
+}
+
+// ProvisionDir return the provision.d directory, every script in it is appended to the ignition script
+func (mc *MachineConfig) ProvisionDir() string {
+	return filepath.Join(mc.Dirs.ConfigDir, define.ProvisionDirName)
+}
+
+// IsProvisionDone reports whether the run-once hook with the given digest already succeeded
+func (mc *MachineConfig) IsProvisionDone(digest string) bool {
+	return slices.Contains(mc.Provision.Done, digest)
 }
 
 // NewMachineConfig initializes and returns a new MachineConfig object using the provided VMOpts configuration.
@@ -185,6 +537,7 @@ func NewMachineConfig(opts *VMOpts) *MachineConfig {
 	mc.Dirs.LogsDir = filepath.Join(Workspace, opts.VMName, define.LogPrefixDir)
 	mc.Dirs.SocksDir = filepath.Join(Workspace, opts.VMName, define.SocksPrefixDir)
 	mc.Dirs.PidsDir = filepath.Join(Workspace, opts.VMName, define.PidsPrefixDir)
+	mc.Dirs.IgnitionDir = filepath.Join(Workspace, opts.VMName, define.IgnPrefixDir)
 
 	mc.ConfigFile = filepath.Join(mc.Dirs.ConfigDir, define.VMConfigJson)
 	mc.Resources = ResourceConfig{
@@ -198,10 +551,12 @@ func NewMachineConfig(opts *VMOpts) *MachineConfig {
 		PublicKeyPath:  filepath.Join(mc.Dirs.DataDir, fmt.Sprintf("%s.pub", define.SSHKey)),
 		Port:           define.DefaultSSHPort,
 		RemoteUsername: define.DefaultUserInVM,
+		HostKeyPath:    filepath.Join(mc.Dirs.DataDir, define.SSHHostKey),
 	}
 
 	mc.PodmanSocks.InHost = filepath.Join(mc.Dirs.SocksDir, define.PodmanHostSocksName)
 	mc.PodmanSocks.InGuest = define.PodmanGuestSocks
+	mc.SetDockerSocks(opts.DockerSocket)
 
 	mc.RestAPISocks = filepath.Join(mc.Dirs.SocksDir, define.RESTAPIEndpointName)
 
@@ -212,9 +567,17 @@ func NewMachineConfig(opts *VMOpts) *MachineConfig {
 	mc.DataDisk.Path = filepath.Join(mc.Dirs.DataDir, "data.img")
 
 	mc.Mounts = volumes.CmdLineVolumesToMounts(opts.Volumes)
+	mc.SetIgnitionMount()
 
 	mc.ReportURL = opts.ReportURL
 
+	mc.Provision.Scripts = ProvisionScriptsFromCmdLine(opts.ProvisionScripts)
+	mc.CACerts = opts.CACerts
+	mc.Proxy = opts.Proxy
+	mc.Registries = opts.Registries
+	mc.Exec = opts.Exec
+	mc.AutoPublishPorts = opts.AutoPublishPorts
+
 	// Set PIDFiles
 	mc.PIDFiles.GvproxyPidFile = filepath.Join(mc.Dirs.PidsDir, define.GvProxyPidName)
 	mc.PIDFiles.KrunKitPidFile = filepath.Join(mc.Dirs.PidsDir, define.KrunkitPidFile)
@@ -253,6 +616,8 @@ func LoadMachineFromPath(p string) (*MachineConfig, error) {
 		return nil, ErrInvalidJsonFormat
 	}
 
+	mc.SetIgnitionMount()
+
 	return mc, nil
 }
 
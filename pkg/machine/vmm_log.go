@@ -0,0 +1,84 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package machine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"bauklotze/pkg/logrotate"
+	"bauklotze/pkg/machine/console"
+	"bauklotze/pkg/machine/define"
+	"bauklotze/pkg/machine/vmconfig"
+	"bauklotze/pkg/pty"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RunVMMInPty starts the hypervisor cmd in a pty. The pty carries the guest console, it is copied into
+// console.log and console.Output, the stderr of the hypervisor (its own log) goes to vmm.log.
+// Both are drained until the hypervisor exits, even if a log file can not be written.
+func RunVMMInPty(mc *vmconfig.MachineConfig, cmd *exec.Cmd) error {
+	vmmLog, err := logrotate.New(mc.GetLogPath(define.VMMLogName), logrotate.DefaultOptions())
+	if err != nil {
+		return fmt.Errorf("unable to open vmm log file: %w", err)
+	}
+	consoleLog, err := logrotate.New(mc.GetLogPath(define.ConsoleLogName), logrotate.DefaultOptions())
+	if err != nil {
+		_ = vmmLog.Close()
+		return fmt.Errorf("unable to open console log file: %w", err)
+	}
+
+	// stderr is a pipe of our own rather than one of exec, its copy ends when the hypervisor exits
+	stderr, stderrW, err := os.Pipe()
+	if err != nil {
+		_ = vmmLog.Close()
+		_ = consoleLog.Close()
+		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	// the pty is only assigned to the unset stdio of cmd
+	cmd.Stderr = stderrW
+	ptmx, err := pty.RunInPty(cmd)
+	_ = stderrW.Close()
+	if err != nil {
+		_ = stderr.Close()
+		_ = vmmLog.Close()
+		_ = consoleLog.Close()
+		return fmt.Errorf("failed to run %s in pty: %w", cmd.Path, err)
+	}
+
+	go func() {
+		_, _ = io.Copy(&bestEffortWriter{name: define.VMMLogName, w: vmmLog}, stderr)
+		_ = stderr.Close()
+		_ = vmmLog.Close()
+	}()
+	go func() {
+		_, _ = io.Copy(io.MultiWriter(console.Output, &bestEffortWriter{name: define.ConsoleLogName, w: consoleLog}), ptmx)
+		_ = ptmx.Close()
+		_ = consoleLog.Close()
+	}()
+	return nil
+}
+
+// bestEffortWriter writes into w until it fails, the error is logged once and the later writes are discarded.
+// A full disk or a failed rotation must not stop the copy, the hypervisor blocks once its pty or pipe is full
+type bestEffortWriter struct {
+	name   string
+	w      io.Writer
+	failed bool
+}
+
+func (b *bestEffortWriter) Write(p []byte) (int, error) {
+	if b.failed {
+		return len(p), nil
+	}
+	if _, err := b.w.Write(p); err != nil {
+		b.failed = true
+		logrus.Warnf("write %s failed, the output is discarded from now on: %v", b.name, err)
+	}
+	return len(p), nil
+}
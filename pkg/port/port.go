@@ -35,3 +35,15 @@ func IsListening(port int) bool {
 
 	return true
 }
+
+// IsUDPInUse test someone binds the target UDP port, a UDP port can only be probed by binding it
+func IsUDPInUse(port int) bool {
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf("%s:%d", define.LocalHostURL, port))
+	if err != nil {
+		return true
+	}
+
+	_ = conn.Close()
+
+	return false
+}
@@ -0,0 +1,43 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package shell
+
+import (
+	"strings"
+)
+
+// isSafe reports whether r can appear unquoted in a POSIX shell word
+func isSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("@%+=:,./_-", r)
+}
+
+// Quote returns s quoted as a single POSIX shell word.
+// Words made only of safe characters are returned unchanged, anything else is
+// wrapped in single quotes, so `"`, `$`, backticks and `\` are never interpreted.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	if strings.IndexFunc(s, func(r rune) bool { return !isSafe(r) }) == -1 {
+		return s
+	}
+
+	// a single quote can not appear inside single quotes, so close the quoted
+	// string, emit an escaped quote and open a new one: ' -> '\''
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Join quotes each argument with Quote and joins them with spaces
+func Join(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, Quote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,86 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package shell
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// echoArgs prints every argument on its own line, wrapped in brackets so empty arguments are visible
+const echoArgs = `for a in "$@"; do printf '[%s]\n' "$a"; done`
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", "''"},
+		{"safe", "/usr/share/zoneinfo/Asia/Shanghai", "/usr/share/zoneinfo/Asia/Shanghai"},
+		{"space", "a b", "'a b'"},
+		{"double quote", `say "hi"`, `'say "hi"'`},
+		{"command substitution", "$(touch /tmp/pwned)", "'$(touch /tmp/pwned)'"},
+		{"backticks", "`id`", "'`id`'"},
+		{"single quote", "it's", `'it'\''s'`},
+		{"only single quotes", "''", `''\'''\'''`},
+		{"newline", "a\nb", "'a\nb'"},
+		{"leading dash", "-rf", "-rf"},
+		{"glob", "*", "'*'"},
+		{"backslash", `a\b`, `'a\b'`},
+		{"semicolon", "a; rm -rf /", "'a; rm -rf /'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Quote(tt.in); got != tt.want {
+				t.Errorf("Quote(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+
+			// the quoted word reaches the shell as exactly one argument, byte for byte
+			out, err := exec.Command("sh", "-c", "set -- "+Quote(tt.in)+"\n"+echoArgs).Output()
+			if err != nil {
+				t.Fatalf("run sh: %v", err)
+			}
+			if want := "[" + tt.in + "]\n"; string(out) != want {
+				t.Errorf("sh received %q, want %q", out, want)
+			}
+		})
+	}
+}
+
+func TestJoin(t *testing.T) {
+	args := []string{"printf", "%s|", "-n", "", "a b", "$HOME", "`id`", "it's"}
+
+	out, err := exec.Command("sh", "-c", Join(args)).Output()
+	if err != nil {
+		t.Fatalf("run sh: %v", err)
+	}
+	if want := "-n||a b|$HOME|`id`|it's|"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+// FuzzQuote checks that any string survives quoting, the shell must pass it to printf byte for byte
+func FuzzQuote(f *testing.F) {
+	for _, seed := range []string{"", "a b", `"`, "$(id)", "`id`", "'", "''", "a\nb", "-rf", "*", `\`, "\t", "\xff\xfe"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		// an argument can not hold a NUL byte
+		if strings.ContainsRune(s, 0) {
+			t.Skip()
+		}
+
+		out, err := exec.Command("sh", "-c", "set -- "+Quote(s)+"\n"+`printf %s "$1"`).Output()
+		if err != nil {
+			t.Fatalf("run sh for %q: %v", s, err)
+		}
+		if string(out) != s {
+			t.Errorf("sh received %q, want %q", out, s)
+		}
+	})
+}
@@ -4,20 +4,34 @@
 package ssh
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+var ErrNoHostKey = errors.New("ssh host key fingerprint is empty")
+
+const dialTimeout = 5 * time.Second
+
 type Config struct {
 	Addr string
 	Port uint
 	User string
 	Auth []ssh.AuthMethod
+	// HostKeyCallback verifies the guest sshd, see FixedHostKey
+	HostKeyCallback ssh.HostKeyCallback
 }
 
-func NewConfig(addr, user string, port uint, keyFile string) (*Config, error) {
+// NewConfig creates the config of the guest sshd, the connection is refused if the host key of the
+// server does not match hostKeyFingerprint
+func NewConfig(addr, user string, port uint, keyFile, hostKeyFingerprint string) (*Config, error) {
+	if hostKeyFingerprint == "" {
+		return nil, ErrNoHostKey
+	}
+
 	f, err := os.ReadFile(keyFile)
 	if err != nil {
 		return nil, fmt.Errorf("read ssh key failed: %w", err)
@@ -34,12 +48,25 @@ func NewConfig(addr, user string, port uint, keyFile string) (*Config, error) {
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(signer),
 		},
+		HostKeyCallback: FixedHostKey(hostKeyFingerprint),
 	}, nil
 }
 
-func NewCmd(config *Config) *Cmd {
+// ClientConfig returns the config used by ssh.Dial
+func (c *Config) ClientConfig() *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            c.Auth,
+		HostKeyCallback: c.HostKeyCallback,
+		// the guest host key is ed25519, do not let the server choose another one
+		HostKeyAlgorithms: []string{ssh.KeyAlgoED25519},
+		Timeout:           dialTimeout,
+	}
+}
+
+func NewCmd(client *Client) *Cmd {
 	return &Cmd{
-		config: config,
+		client: client,
 		// send SIGKILL to stop remote process by default
 		signal: ssh.SIGKILL,
 	}
@@ -4,31 +4,86 @@
 package ssh
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net"
-	"os"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+
+	"bauklotze/pkg/shell"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 )
 
+var ErrTimeout = errors.New("command timed out")
+
 type Cmd struct {
 	// Path to command executable filename
 	name string
 	// Command args.
 	args []string
-	// SSH session.
-	mySession *ssh.Session
 	// Context for cancellation
 	context context.Context
 	// Signal send when the context is canceled
 	signal ssh.Signal
-	// ssh client configure
-	config *Config
+	// shared connection to the guest
+	client *Client
+	// stdin of the remote process, nil means empty
+	stdin io.Reader
+	// timeout of the command, zero means no timeout
+	timeout time.Duration
+	// env is passed by env(1), sshd refuses most of the variables sent by the protocol
+	env map[string]string
+	// dir is the working directory of the remote process
+	dir string
+	// stdout and stderr receive the output as it comes, the output is not kept in Result if set
+	stdout io.Writer
+	stderr io.Writer
+
+	// mu guards session, it is only set while Run is running
+	mu      sync.Mutex
+	session *ssh.Session
+}
+
+// Result is the outcome of a command which ran in the guest
+type Result struct {
+	Stdout []byte `json:"stdout"`
+	Stderr []byte `json:"stderr"`
+	// ExitCode is -1 if the process is killed by a signal or the exit status is missing
+	ExitCode int `json:"exitCode"`
+	// Signal is the name of the signal which killed the process, without the SIG prefix
+	Signal   string        `json:"signal,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// ExitError is returned by Result.Err if the command does not exit with 0
+type ExitError struct {
+	Result *Result
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("exit code %d", e.Result.ExitCode)
+	if e.Result.Signal != "" {
+		msg = fmt.Sprintf("killed by signal %s", e.Result.Signal)
+	}
+	if stderr := strings.TrimSpace(string(e.Result.Stderr)); stderr != "" {
+		lines := strings.Split(stderr, "\n")
+		msg += ": " + lines[len(lines)-1]
+	}
+	return msg
+}
+
+// Err returns an *ExitError if the command failed
+func (r *Result) Err() error {
+	if r.ExitCode == 0 && r.Signal == "" {
+		return nil
+	}
+	return &ExitError{Result: r}
 }
 
 // SetStopSignal sets the signal to send when the context is canceled.
@@ -43,101 +98,174 @@ func (c *Cmd) SetCmdLine(ctx context.Context, name string, args []string) {
 	c.context = ctx
 }
 
-const tcpProto = "tcp"
-
-// NewClient New starts a new ssh connection, the host public key must be in known hosts.
-func (c *Cmd) newClient() (*ssh.Client, error) {
-	sshClient, err := ssh.Dial(
-		tcpProto,
-		net.JoinHostPort(c.config.Addr, fmt.Sprint(c.config.Port)),
-		&ssh.ClientConfig{
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			User:            c.config.User,
-			Auth:            c.config.Auth,
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create ssh client:%w", err)
+// SetArgs sets the command line by argv, argv[0] is the executable. Every argument reaches the remote
+// process as is, the caller never quotes them.
+func (c *Cmd) SetArgs(ctx context.Context, argv ...string) {
+	var name string
+	if len(argv) > 0 {
+		name, argv = argv[0], argv[1:]
 	}
+	c.SetCmdLine(ctx, name, argv)
+}
+
+// SetShell runs script by sh -c, for the callers which need pipes or redirections.
+// Values from the user must be quoted with shell.Quote before they are put in script.
+func (c *Cmd) SetShell(ctx context.Context, script string) {
+	c.SetCmdLine(ctx, "sh", []string{"-c", script})
+}
+
+// SetStdin sets the stdin of the remote process.
+func (c *Cmd) SetStdin(stdin io.Reader) {
+	c.stdin = stdin
+}
 
-	return sshClient, nil
+// SetTimeout stops the remote process after timeout, the stop signal is sent.
+func (c *Cmd) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
 }
 
-// String returns the command line string, with each parameter wrapped in ""
+// SetEnv sets environment variables of the remote process.
+func (c *Cmd) SetEnv(env map[string]string) {
+	c.env = env
+}
+
+// SetOutput streams the output of the remote process into stdout and stderr instead of Result.
+func (c *Cmd) SetOutput(stdout, stderr io.Writer) {
+	c.stdout = stdout
+	c.stderr = stderr
+}
+
+// SetDir sets the working directory of the remote process.
+func (c *Cmd) SetDir(dir string) {
+	c.dir = dir
+}
+
+const tcpProto = "tcp"
+
+// String returns the command line sent to the remote shell, each argument is a single quoted word
 func (c *Cmd) String() string {
-	args := append([]string{c.name}, c.args...)
-	for i, s := range args {
-		args[i] = fmt.Sprintf("\"%s\"", s)
-	}
-	return strings.Join(args, " ")
+	return shell.Join(append([]string{c.name}, c.args...))
 }
 
-// RunCtx executes the given callback within session. Sends SIGINT when the context is canceled.
-func (c *Cmd) RunCtx() error {
-	context.AfterFunc(c.context, func() {
-		if c.mySession != nil {
-			logrus.Warnf("send signal [ %s ] to [ %q ], cause by %v", c.signal, c.name, context.Cause(c.context))
-			if err := c.mySession.Signal(c.signal); err != nil {
-				logrus.Errorf("send signal [ %s ] to [ %q ] failed: %v", c.signal, c.name, err)
-			}
+// cmdLine returns the command line sent to the guest, with the working directory and env applied
+func (c *Cmd) cmdLine() string {
+	line := c.String()
+
+	if len(c.env) > 0 {
+		keys := make([]string, 0, len(c.env))
+		for k := range c.env {
+			keys = append(keys, k)
 		}
-	})
+		sort.Strings(keys)
 
-	client, err := c.newClient()
-	if err != nil {
-		return fmt.Errorf("failed to create ssh client: %w", err)
+		vars := make([]string, 0, len(keys))
+		for _, k := range keys {
+			vars = append(vars, shell.Quote(k+"="+c.env[k]))
+		}
+		line = "env " + strings.Join(vars, " ") + " " + line
 	}
-	defer client.Close() //nolint:errcheck
 
-	c.mySession, err = client.NewSession()
-	if err != nil {
-		return fmt.Errorf("failed to create ssh session: %w", err)
+	if c.dir != "" {
+		line = "cd " + shell.Quote(c.dir) + " && " + line
 	}
 
-	outPipe, err := c.mySession.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get session.StdoutPipe(): %w", err)
-	}
-	errPipe, err := c.mySession.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get session.StderrPipe(): %w", err)
-	}
+	return line
+}
 
-	var wg sync.WaitGroup
-	wg.Add(2) //nolint:mnd
-	logStdErr := func(pipe io.Reader) {
-		_, err := io.Copy(os.Stderr, pipe)
-		if err != nil {
-			logrus.Errorf("failed to copy pipe into os.Stderr")
-		}
-		wg.Done()
+var ErrNotRunning = errors.New("command is not running")
+
+// Signal sends sig to the remote process while Run is running.
+func (c *Cmd) Signal(sig ssh.Signal) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session == nil {
+		return ErrNotRunning
 	}
+	return c.session.Signal(sig) //nolint:wrapcheck
+}
 
-	logStdOut := func(pipe io.Reader) {
-		_, err := io.Copy(os.Stdout, pipe)
-		if err != nil {
-			logrus.Errorf("failed to copy pipe into os.Stdout")
-		}
-		wg.Done()
+// Run executes the command in a session of the shared connection and collects its result. The result
+// is returned with a nil error even if the command fails, see Result.Err. Sends the stop signal when the
+// context is canceled or the timeout is reached.
+func (c *Cmd) Run() (*Result, error) {
+	ctx := c.context
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, c.timeout, ErrTimeout)
+		defer cancel()
 	}
 
-	if err = c.mySession.Start(c.String()); err != nil {
-		return fmt.Errorf("failed to start ssh command: %w", err)
+	session, err := c.client.NewSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ssh session: %w", err)
 	}
+	defer session.Close() //nolint:errcheck
 
+	c.mu.Lock()
+	c.session = session
+	c.mu.Unlock()
 	defer func() {
-		_ = c.mySession.Close()
-		c.mySession = nil
+		c.mu.Lock()
+		c.session = nil
+		c.mu.Unlock()
 	}()
 
-	go logStdOut(outPipe)
-	go logStdErr(errPipe)
+	stop := context.AfterFunc(ctx, func() {
+		logrus.Warnf("send signal [ %s ] to [ %q ], cause by %v", c.signal, c.name, context.Cause(ctx))
+		if err := session.Signal(c.signal); err != nil {
+			logrus.Errorf("send signal [ %s ] to [ %q ] failed: %v", c.signal, c.name, err)
+		}
+		// the session is closed even if the remote process ignores the signal
+		_ = session.Close()
+	})
+	defer stop()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	session.Stdin = c.stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if c.stdout != nil {
+		session.Stdout = c.stdout
+	}
+	if c.stderr != nil {
+		session.Stderr = c.stderr
+	}
+
+	start := time.Now()
+	err = session.Run(c.cmdLine())
+
+	result := &Result{
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.Bytes(),
+		Duration: time.Since(start),
+	}
 
-	wg.Wait()
+	var exitErr *ssh.ExitError
+	var runErr error
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+		if exitErr.Signal() != "" {
+			result.ExitCode = -1
+			result.Signal = exitErr.Signal()
+		}
+	default:
+		// the exit status is missing, or the session is broken
+		result.ExitCode = -1
+		runErr = err
+	}
+
+	// the session is closed when the context is done, report the cause rather than the broken session
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("command %q stopped: %w", c.name, context.Cause(ctx))
+	}
 
-	if err = c.mySession.Wait(); err != nil {
-		return fmt.Errorf("failed to wait ssh command: %w", err)
+	var missingErr *ssh.ExitMissingError
+	if runErr != nil && !errors.As(runErr, &missingErr) {
+		return result, fmt.Errorf("failed to run ssh command: %w", runErr)
 	}
 
-	return nil
+	return result, nil
 }
@@ -0,0 +1,89 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ssh
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// printArgs prints the working directory, the env variables A and B, and the arguments, separated by |
+var printArgs = []string{"sh", "-c", `printf '%s|' "$PWD" "$A" "$B" "$@"`, "_"}
+
+// runCmdLine runs the command line of c in a local shell, like sshd does in the guest
+func runCmdLine(t *testing.T, c *Cmd) string {
+	t.Helper()
+	out, err := exec.Command("sh", "-c", c.cmdLine()).Output()
+	if err != nil {
+		t.Fatalf("run %q: %v", c.cmdLine(), err)
+	}
+	return string(out)
+}
+
+func TestCmdLine(t *testing.T) {
+	hostile := []string{"", "a b", `"`, "$(touch pwned)", "`touch pwned`", "it's", "a\nb", "-n", "*", `\`, "; touch pwned"}
+
+	dir := filepath.Join(t.TempDir(), "it's $(touch pwned) `x`")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Cmd{}
+	c.SetArgs(context.Background(), append(printArgs, hostile...)...)
+	c.SetEnv(map[string]string{"A": "$HOME `id` it's", "B": "x\ny"})
+	c.SetDir(dir)
+
+	got := runCmdLine(t, c)
+	want := strings.Join(append([]string{dir, "$HOME `id` it's", "x\ny"}, hostile...), "|") + "|"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Error("a hostile argument was executed")
+	}
+}
+
+func TestCmdString(t *testing.T) {
+	c := &Cmd{}
+	c.SetArgs(context.Background(), "echo", "it's", "$HOME")
+
+	if want := `echo 'it'\''s' '$HOME'`; c.String() != want {
+		t.Errorf("got %q, want %q", c.String(), want)
+	}
+	// without env and dir, String is the command line
+	if c.cmdLine() != c.String() {
+		t.Errorf("cmdLine %q differs from String %q", c.cmdLine(), c.String())
+	}
+}
+
+// FuzzCmdLine checks that an argument, an env value and the working directory reach the command as is
+func FuzzCmdLine(f *testing.F) {
+	f.Add("$(id)", "`id`", "it's")
+	f.Add("-n", "a\nb", "*")
+
+	f.Fuzz(func(t *testing.T, arg, env, name string) {
+		if strings.ContainsRune(arg+env+name, 0) || strings.ContainsRune(name, '/') || name == "" || name == "." || name == ".." {
+			t.Skip()
+		}
+
+		dir := filepath.Join(t.TempDir(), name)
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Skip()
+		}
+
+		c := &Cmd{}
+		c.SetArgs(context.Background(), append(printArgs, arg)...)
+		c.SetEnv(map[string]string{"A": env})
+		c.SetDir(dir)
+
+		if got, want := runCmdLine(t, c), dir+"|"+env+"||"+arg+"|"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
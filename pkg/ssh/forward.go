@@ -0,0 +1,161 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"time"
+
+	"bauklotze/pkg/shell"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+const forwardRetryBackoff = time.Second
+
+// ForwardRemoteUnix listens on the unix socket remote in the guest, and forwards every connection
+// to the unix socket local in the host. The listener is recreated when the ssh connection is lost,
+// it returns when ctx is done.
+func ForwardRemoteUnix(ctx context.Context, c *Client, remote, local string) error {
+	for {
+		err := forwardRemoteUnix(ctx, c, remote, local)
+		if ctx.Err() != nil {
+			return fmt.Errorf("forward context cancelled: %w", context.Cause(ctx))
+		}
+		if errors.Is(err, ErrClientClosed) {
+			return err
+		}
+		logrus.Warnf("forward remote unix socket %q stopped: %v, retry", remote, err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(forwardRetryBackoff):
+		}
+	}
+}
+
+func forwardRemoteUnix(ctx context.Context, c *Client, remote, local string) error {
+	session, err := c.NewSession(ctx)
+	if err != nil {
+		return err
+	}
+	// the socket file left by the previous listener makes the listen fail
+	cleanup := fmt.Sprintf("mkdir -p %s && rm -f %s", shell.Quote(path.Dir(remote)), shell.Quote(remote))
+	err = session.Run(cleanup)
+	_ = session.Close()
+	if err != nil {
+		return fmt.Errorf("failed to clean remote socket %q: %w", remote, err)
+	}
+
+	listener, err := c.ListenUnix(ctx, remote)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = listener.Close()
+	})
+	defer stop()
+	defer listener.Close() //nolint:errcheck
+
+	logrus.Infof("forward remote unix socket %q to %q", remote, local)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("accept on remote %q failed: %w", remote, err)
+		}
+		go forwardConn(ctx, conn, local)
+	}
+}
+
+// ForwardLocalUnix accepts the connections of listener in the host, and forwards every connection
+// to the unix socket remote in the guest. The connections to the guest are opened on the shared
+// ssh connection of c, so the host key of the guest is verified. It returns when ctx is done.
+func ForwardLocalUnix(ctx context.Context, c *Client, listener net.Listener, remote string) error {
+	stop := context.AfterFunc(ctx, func() {
+		_ = listener.Close()
+	})
+	defer stop()
+	defer listener.Close() //nolint:errcheck
+
+	logrus.Infof("forward local unix socket %q to %q", listener.Addr(), remote)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("forward context cancelled: %w", context.Cause(ctx))
+			}
+			return fmt.Errorf("accept on local %q failed: %w", listener.Addr(), err)
+		}
+		go func() {
+			defer conn.Close() //nolint:errcheck
+
+			to, err := c.DialUnix(ctx, remote)
+			if err != nil {
+				logrus.Warnf("dial remote %q failed: %v", remote, err)
+				return
+			}
+			pipeConn(ctx, conn, to, remote)
+		}()
+	}
+}
+
+func forwardConn(ctx context.Context, from net.Conn, local string) {
+	defer from.Close() //nolint:errcheck
+
+	var d net.Dialer
+	to, err := d.DialContext(ctx, "unix", local)
+	if err != nil {
+		logrus.Warnf("dial local %q failed: %v", local, err)
+		return
+	}
+	pipeConn(ctx, from, to, local)
+}
+
+// closeWriter is implemented by *net.UnixConn and by the connections over ssh channels
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// pipeConn copies between from and to. The EOF of one direction is passed on as a half close, so the
+// other direction keeps flowing, e.g. the output of an attached container whose stdin is at EOF.
+// Both are closed when both directions are done, when a copy fails or when ctx is done.
+func pipeConn(ctx context.Context, from, to net.Conn, name string) {
+	closeBoth := func() {
+		_ = from.Close()
+		_ = to.Close()
+	}
+	stop := context.AfterFunc(ctx, closeBoth)
+	defer stop()
+	defer closeBoth()
+
+	var g errgroup.Group
+	pipe := func(dst, src net.Conn) func() error {
+		return func() error {
+			if _, err := io.Copy(dst, src); err != nil {
+				closeBoth()
+				if errors.Is(err, net.ErrClosed) {
+					return nil
+				}
+				return err //nolint:wrapcheck
+			}
+			if cw, ok := dst.(closeWriter); ok {
+				return cw.CloseWrite() //nolint:wrapcheck
+			}
+			// dst can not be half closed, closing it is the only way to pass on the EOF
+			return dst.Close() //nolint:wrapcheck
+		}
+	}
+	g.Go(pipe(to, from))
+	g.Go(pipe(from, to))
+
+	if err := g.Wait(); err != nil {
+		logrus.Debugf("forward connection to %q closed: %v", name, err)
+	}
+}
@@ -0,0 +1,115 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ssh
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// unixPair returns both ends of a unix socket connection, the path is short enough for darwin
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "s"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close() //nolint:errcheck
+
+	client, err := net.DialUnix("unix", nil, ln.Addr().(*net.UnixAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ln.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+// TestPipeConnHalfClose sends the input of an attached stream, closes it, and expects the output
+// written after the EOF, as docker run -i does
+func TestPipeConnHalfClose(t *testing.T) {
+	client, fromClient := unixPair(t)
+	toServer, server := unixPair(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pipeConn(context.Background(), fromClient, toServer, "test")
+	}()
+
+	go func() {
+		in, err := io.ReadAll(server)
+		if err != nil {
+			t.Errorf("server read: %v", err)
+		}
+		// the output is written after the input is at EOF
+		time.Sleep(50 * time.Millisecond)
+		_, _ = server.Write(append([]byte("out:"), in...))
+		_ = server.Close()
+	}()
+
+	if _, err := client.Write([]byte("in")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	out, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "out:in" {
+		t.Fatalf("got %q after the half close, want %q", out, "out:in")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeConn did not return after both directions finished")
+	}
+}
+
+func TestPipeConnCancel(t *testing.T) {
+	client, fromClient := unixPair(t)
+	toServer, _ := unixPair(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pipeConn(ctx, fromClient, toServer, "test")
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeConn did not return after ctx is done")
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("got %v from the closed forward, want EOF", err)
+	}
+}
@@ -0,0 +1,75 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrHostKeyMismatch = errors.New("ssh host key mismatch")
+
+// GenerateHostKey creates an ed25519 host key for the guest sshd, the private key is written to path
+// and the public key to path.pub. It returns the SHA256 fingerprint of the public key.
+func GenerateHostKey(path string) (string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate host key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal host key: %w", err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert host public key: %w", err)
+	}
+
+	// remove first, WriteFile keeps the permission of an existing file
+	_ = os.Remove(path)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil { //nolint:mnd
+		return "", fmt.Errorf("failed to write host key: %w", err)
+	}
+	if err := os.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(sshPub), 0644); err != nil { //nolint:mnd
+		return "", fmt.Errorf("failed to write host public key: %w", err)
+	}
+
+	return ssh.FingerprintSHA256(sshPub), nil
+}
+
+// HostKeyFingerprint returns the SHA256 fingerprint of the host key at path
+func HostKeyFingerprint(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read host key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse host key: %w", err)
+	}
+
+	return ssh.FingerprintSHA256(signer.PublicKey()), nil
+}
+
+// FixedHostKey accepts only the host key with the given SHA256 fingerprint, like a known_hosts with one entry
+func FixedHostKey(fingerprint string) ssh.HostKeyCallback {
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		got := ssh.FingerprintSHA256(key)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(fingerprint)) != 1 {
+			return fmt.Errorf("%w: %s presents %s, expect %s", ErrHostKeyMismatch, hostname, got, fingerprint)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,316 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrClientClosed     = errors.New("ssh client is closed")
+	errKeepaliveTimeout = errors.New("keepalive timeout")
+	errConnClosed       = errors.New("connection closed by peer")
+)
+
+const (
+	keepaliveInterval = 5 * time.Second
+	keepaliveTimeout  = 10 * time.Second
+	keepaliveRequest  = "keepalive@openssh.com"
+)
+
+// Client is a long-lived connection to the guest sshd shared by all the guest operations, the sessions
+// are multiplexed on it. It connects lazily, probes the connection with keepalives and reconnects on demand
+// once the connection is lost.
+type Client struct {
+	config *Config
+
+	mu            sync.Mutex
+	client        *ssh.Client
+	closed        bool
+	connects      int
+	connectedAt   time.Time
+	lastKeepalive time.Time
+	rtt           time.Duration
+	lastErr       error
+	// dialing is the connect in progress, it runs without mu so Health and Close do not wait for it
+	dialing *dialCall
+}
+
+// dialCall is a connect shared by the callers of get which find no connection
+type dialCall struct {
+	done chan struct{}
+	err  error
+	// cancelled is set if the context of the caller which dialed was done, the others dial again
+	cancelled bool
+}
+
+// Health is the state of the connection to the guest sshd
+type Health struct {
+	Connected     bool      `json:"connected"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	Reconnects    int       `json:"reconnects"`
+	LastKeepalive time.Time `json:"lastKeepalive"`
+	// KeepaliveRTTMs is the round trip time of the last keepalive in milliseconds
+	KeepaliveRTTMs int64  `json:"keepaliveRttMs"`
+	LastError      string `json:"lastError,omitempty"`
+}
+
+func NewClient(config *Config) *Client {
+	return &Client{config: config}
+}
+
+// dial connects to the guest sshd and verifies its host key
+func dial(ctx context.Context, c *Config) (*ssh.Client, error) {
+	addr := net.JoinHostPort(c.Addr, fmt.Sprint(c.Port))
+
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, tcpProto, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial ssh: %w", err)
+	}
+
+	// the handshake must not hang forever if the guest sshd is not ready, nor outlive ctx
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	sc, chans, reqs, err := ssh.NewClientConn(conn, addr, c.ClientConfig())
+	if !stop() && err == nil {
+		_ = sc.Close()
+		err = context.Cause(ctx)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to dial ssh: %w", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(sc, chans, reqs), nil
+}
+
+// get returns the current connection, or connects if there is none. Concurrent callers share one connect.
+func (c *Client) get(ctx context.Context) (*ssh.Client, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return nil, ErrClientClosed
+		}
+		if c.client != nil {
+			client := c.client
+			c.mu.Unlock()
+			return client, nil
+		}
+
+		if call := c.dialing; call != nil {
+			c.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("wait for ssh connection: %w", context.Cause(ctx))
+			case <-call.done:
+			}
+			if call.err != nil && !call.cancelled {
+				return nil, call.err
+			}
+			continue
+		}
+
+		call := &dialCall{done: make(chan struct{})}
+		c.dialing = call
+		c.mu.Unlock()
+
+		client, err := dial(ctx, c.config)
+		if err := c.publish(ctx, call, client, err); err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+}
+
+// publish records the result of the connect of call and wakes up the callers waiting for it
+func (c *Client) publish(ctx context.Context, call *dialCall, client *ssh.Client, err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(call.done)
+
+	c.dialing = nil
+	call.err, call.cancelled = err, ctx.Err() != nil
+	if err != nil {
+		c.lastErr = err
+		return err
+	}
+	if c.closed {
+		_ = client.Close()
+		call.err = ErrClientClosed
+		return ErrClientClosed
+	}
+
+	if c.connects > 0 {
+		logrus.Infof("ssh client reconnected to %s:%d", c.config.Addr, c.config.Port)
+	}
+	c.client = client
+	c.connects++
+	c.connectedAt = time.Now()
+	c.lastErr = nil
+
+	go c.keepalive(client)
+	return nil
+}
+
+// drop forgets the connection if it is still the current one, the next call reconnects
+func (c *Client) drop(client *ssh.Client, err error) {
+	c.mu.Lock()
+	if c.client == client {
+		c.client = nil
+		c.lastErr = err
+		logrus.Warnf("ssh connection to %s:%d lost: %v", c.config.Addr, c.config.Port, err)
+	}
+	c.mu.Unlock()
+
+	_ = client.Close()
+}
+
+func (c *Client) keepalive(client *ssh.Client) {
+	done := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			c.drop(client, errConnClosed)
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		errCh := make(chan error, 1)
+		go func() {
+			// the server replies a failure for an unknown request, it is still alive
+			_, _, err := client.SendRequest(keepaliveRequest, true, nil)
+			errCh <- err
+		}()
+
+		select {
+		case <-done:
+			c.drop(client, errConnClosed)
+			return
+		case <-time.After(keepaliveTimeout):
+			c.drop(client, errKeepaliveTimeout)
+			return
+		case err := <-errCh:
+			if err != nil {
+				c.drop(client, fmt.Errorf("keepalive failed: %w", err))
+				return
+			}
+			c.mu.Lock()
+			c.lastKeepalive = time.Now()
+			c.rtt = time.Since(start)
+			c.mu.Unlock()
+		}
+	}
+}
+
+// NewSession opens a session on the shared connection, a broken connection is replaced once
+func (c *Client) NewSession(ctx context.Context) (*ssh.Session, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := c.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		session, err := client.NewSession()
+		if err == nil {
+			return session, nil
+		}
+
+		c.drop(client, err)
+		if attempt > 0 {
+			return nil, fmt.Errorf("failed to create ssh session: %w", err)
+		}
+	}
+}
+
+// ListenUnix listens on a unix socket in the guest, the listener is closed when the connection is lost
+func (c *Client) ListenUnix(ctx context.Context, socketPath string) (net.Listener, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := client.ListenUnix(socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("remote listen on %q failed: %w", socketPath, err)
+	}
+	return l, nil
+}
+
+// DialUnix connects to a unix socket in the guest, a broken connection is replaced once
+func (c *Client) DialUnix(ctx context.Context, socketPath string) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := c.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := client.DialContext(ctx, "unix", socketPath)
+		if err == nil {
+			return conn, nil
+		}
+
+		// the guest rejected the channel, e.g. the socket is not listening yet, the connection is fine
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) || ctx.Err() != nil || attempt > 0 {
+			return nil, fmt.Errorf("failed to dial remote %q: %w", socketPath, err)
+		}
+		c.drop(client, err)
+	}
+}
+
+// Health returns the state of the connection
+func (c *Client) Health() Health {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := Health{
+		Connected:      c.client != nil,
+		ConnectedAt:    c.connectedAt,
+		LastKeepalive:  c.lastKeepalive,
+		KeepaliveRTTMs: c.rtt.Milliseconds(),
+	}
+	if c.connects > 1 {
+		h.Reconnects = c.connects - 1
+	}
+	if c.lastErr != nil {
+		h.LastError = c.lastErr.Error()
+	}
+	return h
+}
+
+// Close closes the connection, the client can not be used anymore
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err //nolint:wrapcheck
+}
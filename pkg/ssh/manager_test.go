@@ -0,0 +1,105 @@
+//  SPDX-FileCopyrightText: 2024-2025 OOMOL, Inc. <https://www.oomol.com>
+//  SPDX-License-Identifier: MPL-2.0
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// hangingServer accepts tcp connections and never starts the ssh handshake, like a guest which is booting
+func hangingServer(t *testing.T) *Config {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns []net.Conn
+	var mu sync.Mutex
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		_ = ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+
+	return &Config{
+		Addr:            "127.0.0.1",
+		Port:            uint(ln.Addr().(*net.TCPAddr).Port),
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec
+	}
+}
+
+func TestClientHealthDuringDial(t *testing.T) {
+	c := NewClient(hangingServer(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dialed := make(chan error, 1)
+	go func() {
+		_, err := c.get(ctx)
+		dialed <- err
+	}()
+	// let the dial reach the handshake
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan Health, 1)
+	go func() { done <- c.Health() }()
+	select {
+	case h := <-done:
+		if h.Connected {
+			t.Fatal("got connected during the handshake")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Health blocked by the dial in progress")
+	}
+
+	// a second caller waits for the dial in progress instead of dialing again, and gives up with its context
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer waitCancel()
+	if _, err := c.get(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v while waiting for the dial, want deadline exceeded", err)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by the dial in progress")
+	}
+
+	cancel()
+	select {
+	case err := <-dialed:
+		if err == nil {
+			t.Fatal("dial succeeded against a server without ssh")
+		}
+	case <-time.After(2 * dialTimeout):
+		t.Fatal("dial did not return")
+	}
+	if _, err := c.get(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("got %v after Close, want ErrClientClosed", err)
+	}
+}